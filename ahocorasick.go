@@ -0,0 +1,163 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+type ahoCorasickMatch struct {
+	start   int
+	end     int
+	pattern int
+}
+
+// finds occurrences of many literal patterns in a single pass over the input. the automaton is a dense
+// table over byte classes - bytes which appear in no pattern share a class which leads back to the root,
+// and when folding case, ASCII letters share a class with their upper case
+type ahoCorasickMatcher struct {
+	byteClasses [256]int32
+	classCount  int32
+
+	// transitions[state*classCount+class] is the next state, failure transitions included
+	transitions []int32
+
+	// the longest pattern ending at each state (-1 if none)
+	outputs []int32
+
+	patternLengths []int
+}
+
+func newAhoCorasickMatcher(patterns [][]byte, foldCase bool) *ahoCorasickMatcher {
+	matcher := ahoCorasickMatcher{
+		patternLengths: make([]int, len(patterns)),
+	}
+
+	// class 0 is for bytes in no pattern
+	matcher.classCount = 1
+	for _, pattern := range patterns {
+		for _, patternByte := range pattern {
+			if foldCase {
+				patternByte = toLowerASCII(patternByte)
+			}
+
+			if matcher.byteClasses[patternByte] == 0 {
+				matcher.byteClasses[patternByte] = matcher.classCount
+				matcher.classCount++
+			}
+		}
+	}
+
+	if foldCase {
+		for lowerByte := byte('a'); lowerByte <= 'z'; lowerByte++ {
+			matcher.byteClasses[lowerByte-'a'+'A'] = matcher.byteClasses[lowerByte]
+		}
+	}
+
+	// build the trie, with 0 as "no transition" since nothing leads back to the root
+	matcher.transitions = make([]int32, matcher.classCount)
+	matcher.outputs = []int32{-1}
+	stateCount := int32(1)
+
+	for patternIndex, pattern := range patterns {
+		matcher.patternLengths[patternIndex] = len(pattern)
+		if len(pattern) == 0 {
+			continue
+		}
+
+		state := int32(0)
+		for _, patternByte := range pattern {
+			if foldCase {
+				patternByte = toLowerASCII(patternByte)
+			}
+
+			transitionIndex := state*matcher.classCount + matcher.byteClasses[patternByte]
+			if matcher.transitions[transitionIndex] == 0 {
+				matcher.transitions[transitionIndex] = stateCount
+				matcher.transitions = append(matcher.transitions, make([]int32, matcher.classCount)...)
+				matcher.outputs = append(matcher.outputs, -1)
+				stateCount++
+			}
+
+			state = matcher.transitions[transitionIndex]
+		}
+
+		// for duplicates, the first pattern wins
+		if matcher.outputs[state] == -1 {
+			matcher.outputs[state] = int32(patternIndex)
+		}
+	}
+
+	// breadth first, fill the missing transitions with those of the failure state, whose own are
+	// already complete
+	failures := make([]int32, stateCount)
+	queue := make([]int32, 0, stateCount)
+
+	for class := int32(0); class < matcher.classCount; class++ {
+		if nextState := matcher.transitions[class]; nextState != 0 {
+			queue = append(queue, nextState)
+		}
+	}
+
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+
+		// a state's own pattern is longer than any pattern ending at its failure state
+		if matcher.outputs[state] == -1 {
+			matcher.outputs[state] = matcher.outputs[failures[state]]
+		}
+
+		for class := int32(0); class < matcher.classCount; class++ {
+			transitionIndex := state*matcher.classCount + class
+			failureTransition := matcher.transitions[failures[state]*matcher.classCount+class]
+
+			if nextState := matcher.transitions[transitionIndex]; nextState != 0 {
+				failures[nextState] = failureTransition
+				queue = append(queue, nextState)
+			} else {
+				matcher.transitions[transitionIndex] = failureTransition
+			}
+		}
+	}
+
+	return &matcher
+}
+
+// appends the longest match ending at each position of the input to matches. doesn't allocate if
+// nothing matches
+func (m *ahoCorasickMatcher) appendMatches(matches []ahoCorasickMatch, input []byte) []ahoCorasickMatch {
+	state := int32(0)
+
+	for inputIndex, inputByte := range input {
+		state = m.transitions[state*m.classCount+m.byteClasses[inputByte]]
+
+		if patternIndex := m.outputs[state]; patternIndex != -1 {
+			matches = append(matches, ahoCorasickMatch{
+				start:   inputIndex + 1 - m.patternLengths[patternIndex],
+				end:     inputIndex + 1,
+				pattern: int(patternIndex),
+			})
+		}
+	}
+
+	return matches
+}
+
+func toLowerASCII(b byte) byte {
+	if b >= 'A' && b <= 'Z' {
+		return b - 'A' + 'a'
+	}
+
+	return b
+}
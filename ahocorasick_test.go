@@ -0,0 +1,104 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ahoCorasickSuite struct {
+	suite.Suite
+}
+
+func (suite *ahoCorasickSuite) TestLongestMatchPerPosition() {
+	matcher := newAhoCorasickMatcher([][]byte{
+		[]byte("he"),
+		[]byte("she"),
+		[]byte("his"),
+		[]byte("hers"),
+	}, false)
+
+	suite.Require().Equal([]ahoCorasickMatch{
+		{start: 1, end: 4, pattern: 1},
+		{start: 2, end: 6, pattern: 3},
+		{start: 7, end: 10, pattern: 2},
+	}, matcher.appendMatches(nil, []byte("ushers his")))
+
+	suite.Require().Nil(matcher.appendMatches(nil, []byte("nothing to see")))
+}
+
+func (suite *ahoCorasickSuite) TestFoldCase() {
+	matcher := newAhoCorasickMatcher([][]byte{[]byte("PassWord")}, true)
+
+	suite.Require().Equal([]ahoCorasickMatch{
+		{start: 0, end: 8, pattern: 0},
+		{start: 9, end: 17, pattern: 0},
+	}, matcher.appendMatches(nil, []byte("PASSWORD password passw0rd")))
+}
+
+func (suite *ahoCorasickSuite) TestMatchesNaiveSearch() {
+	random := rand.New(rand.NewSource(1))
+	randomBytes := func(length int) []byte {
+		randomBytes := make([]byte, length)
+		for byteIndex := range randomBytes {
+			randomBytes[byteIndex] = "abc"[random.Intn(3)]
+		}
+
+		return randomBytes
+	}
+
+	for iteration := 0; iteration < 100; iteration++ {
+		var patterns [][]byte
+		for patternIndex := 0; patternIndex < 1+random.Intn(5); patternIndex++ {
+			patterns = append(patterns, randomBytes(1+random.Intn(4)))
+		}
+
+		input := randomBytes(50)
+
+		// the longest pattern ending at each position, the first of equal ones
+		var expectedMatches []ahoCorasickMatch
+		for end := 1; end <= len(input); end++ {
+			longestPatternIndex := -1
+
+			for patternIndex, pattern := range patterns {
+				if bytes.HasSuffix(input[:end], pattern) &&
+					(longestPatternIndex == -1 || len(pattern) > len(patterns[longestPatternIndex])) {
+					longestPatternIndex = patternIndex
+				}
+			}
+
+			if longestPatternIndex != -1 {
+				expectedMatches = append(expectedMatches, ahoCorasickMatch{
+					start:   end - len(patterns[longestPatternIndex]),
+					end:     end,
+					pattern: longestPatternIndex,
+				})
+			}
+		}
+
+		matcher := newAhoCorasickMatcher(patterns, false)
+		suite.Require().Equal(expectedMatches, matcher.appendMatches(nil, input), "patterns: %q", patterns)
+	}
+}
+
+func TestAhoCorasickTestSuite(t *testing.T) {
+	suite.Run(t, new(ahoCorasickSuite))
+}
@@ -0,0 +1,629 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type BatchConfig struct {
+
+	// send a batch once it holds this many entries (defaults to 500)
+	MaxEntries int
+
+	// send a batch once it holds this many bytes (defaults to 1MiB)
+	MaxBytes int
+
+	// send a non-empty batch at least this often (defaults to 1 second)
+	FlushInterval time.Duration
+
+	// number of batches waiting to be sent before overflowing to the spool, or being dropped (defaults to 16)
+	QueueSize int
+
+	// retry policy of a failed send
+	Backoff BackoffConfig
+
+	// directory in which batches that could not be sent are kept, and retried later - even across
+	// restarts (empty disables spooling)
+	SpoolDir string
+
+	// maximum size of the spool directory. the oldest batches are discarded beyond it (defaults to 100MiB)
+	SpoolMaxBytes int64
+
+	// called with the reason whenever entries are dropped, e.g. to report it with another logger (if nil,
+	// entries are dropped silently)
+	ErrorHandler func(error)
+}
+
+type BackoffConfig struct {
+
+	// wait before the first retry (defaults to 100 milliseconds)
+	InitialInterval time.Duration
+
+	// maximum wait between retries (defaults to 10 seconds)
+	MaxInterval time.Duration
+
+	// factor by which the wait grows on every retry (defaults to 2)
+	Multiplier float64
+
+	// number of retries after the first failed attempt (defaults to 5, negative disables retries)
+	MaxRetries int
+}
+
+// returned by a send function when retrying would not help (e.g. the server rejected the request)
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// returned by a send function when only some of the entries failed (retryably), so that only
+// those are retried
+type partialSendError struct {
+	entries [][]byte
+	err     error
+}
+
+func (e *partialSendError) Error() string {
+	return fmt.Sprintf("%d entries failed, %v", len(e.entries), e.err)
+}
+
+func (e *partialSendError) Unwrap() error {
+	return e.err
+}
+
+var errBatchWriterClosed = errors.New("writer is closed")
+
+type batch struct {
+	entries [][]byte
+	done    chan struct{}
+}
+
+// accumulates written entries and sends them in batches from a background goroutine, retrying with
+// backoff and spooling to disk on failure. entries are sent in write order, except spooled batches
+// which are sent once the destination is reachable again
+type batchWriter struct {
+	config       BatchConfig
+	send         func([][]byte) error
+	lock         sync.Mutex
+	pending      [][]byte
+	pendingBytes int
+	batchChan    chan *batch
+	closingChan  chan struct{}
+	stopChan     chan struct{}
+	stopped      chan struct{}
+	spool        *diskSpool
+	closed       bool
+}
+
+func newBatchWriter(config BatchConfig, send func([][]byte) error) (*batchWriter, error) {
+	if config.MaxEntries == 0 {
+		config.MaxEntries = 500
+	}
+
+	if config.MaxBytes == 0 {
+		config.MaxBytes = 1024 * 1024
+	}
+
+	if config.FlushInterval == 0 {
+		config.FlushInterval = time.Second
+	}
+
+	if config.QueueSize == 0 {
+		config.QueueSize = 16
+	}
+
+	if config.SpoolMaxBytes == 0 {
+		config.SpoolMaxBytes = 100 * 1024 * 1024
+	}
+
+	config.Backoff = populateBackoffConfigDefaults(config.Backoff)
+
+	newBatchWriter := batchWriter{
+		config:      config,
+		send:        send,
+		batchChan:   make(chan *batch, config.QueueSize),
+		closingChan: make(chan struct{}),
+		stopChan:    make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+
+	if config.SpoolDir != "" {
+		spool, err := newDiskSpool(config.SpoolDir, config.SpoolMaxBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		newBatchWriter.spool = spool
+	}
+
+	go newBatchWriter.sendLoop()
+
+	return &newBatchWriter, nil
+}
+
+func (w *batchWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	// the caller may reuse p
+	entry := make([]byte, len(p))
+	copy(entry, p)
+
+	w.pending = append(w.pending, entry)
+	w.pendingBytes += len(entry)
+
+	if len(w.pending) >= w.config.MaxEntries || w.pendingBytes >= w.config.MaxBytes {
+		w.enqueuePending()
+	}
+
+	return len(p), nil
+}
+
+// Flush sends all pending entries and waits for them to be sent (or spooled)
+func (w *batchWriter) Flush() error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return nil
+	}
+
+	flushBatch := &batch{entries: w.pending, done: make(chan struct{})}
+	w.pending = nil
+	w.pendingBytes = 0
+	w.lock.Unlock()
+
+	// block - the caller wants everything written up to now to be handled. a racing close may stop the
+	// background goroutine before it gets to the batch, in which case it's handled here
+	select {
+	case w.batchChan <- flushBatch:
+	case <-w.stopped:
+		w.spoolOrDrop(flushBatch.entries, errBatchWriterClosed)
+		return nil
+	}
+
+	select {
+	case <-flushBatch.done:
+	case <-w.stopped:
+		w.dropQueuedBatches()
+	}
+
+	return nil
+}
+
+// Close sends pending entries and stops the background goroutine. entries which fail to be sent while
+// closing aren't retried, but spooled (or dropped)
+func (w *batchWriter) Close() error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return nil
+	}
+
+	w.closed = true
+	finalBatch := &batch{entries: w.pending, done: make(chan struct{})}
+	w.pending = nil
+	w.pendingBytes = 0
+	w.lock.Unlock()
+
+	// failed sends aren't retried from now on, but spooled (or dropped) right away, so that closing while the
+	// destination is unreachable doesn't wait for every queued batch to use up its retries
+	close(w.closingChan)
+
+	w.batchChan <- finalBatch
+	<-finalBatch.done
+
+	close(w.stopChan)
+	<-w.stopped
+
+	return nil
+}
+
+// must be called with the lock held
+func (w *batchWriter) enqueuePending() {
+	if len(w.pending) == 0 {
+		return
+	}
+
+	pendingBatch := &batch{entries: w.pending}
+	w.pending = nil
+	w.pendingBytes = 0
+
+	select {
+	case w.batchChan <- pendingBatch:
+	default:
+
+		// the destination can't keep up - never block the logging goroutine
+		w.spoolOrDrop(pendingBatch.entries, errors.New("send queue is full"))
+	}
+}
+
+func (w *batchWriter) sendLoop() {
+	defer close(w.stopped)
+
+	ticker := time.NewTicker(w.config.FlushInterval)
+	defer ticker.Stop()
+
+	// send whatever was left in the spool by a previous run
+	w.drainSpool()
+
+	for {
+		select {
+		case pendingBatch := <-w.batchChan:
+			w.sendBatch(pendingBatch.entries)
+
+			if pendingBatch.done != nil {
+				close(pendingBatch.done)
+			}
+
+		case <-ticker.C:
+			w.lock.Lock()
+			w.enqueuePending()
+			w.lock.Unlock()
+
+			w.drainSpool()
+
+		case <-w.stopChan:
+
+			// a flush racing with close may have queued a batch after the final one
+			w.dropQueuedBatches()
+			return
+		}
+	}
+}
+
+// spools (or drops) the batches left in the queue once the background goroutine stopped
+func (w *batchWriter) dropQueuedBatches() {
+	for {
+		select {
+		case pendingBatch := <-w.batchChan:
+			w.spoolOrDrop(pendingBatch.entries, errBatchWriterClosed)
+			if pendingBatch.done != nil {
+				close(pendingBatch.done)
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (w *batchWriter) sendBatch(entries [][]byte) {
+	if len(entries) == 0 {
+		return
+	}
+
+	failedEntries, err := w.sendWithRetries(entries)
+	if err == nil {
+		return
+	}
+
+	var permanentErr *permanentError
+	if errors.As(err, &permanentErr) {
+
+		// spooling a rejected batch would only get it rejected again later
+		reportDroppedEntries(w.config.ErrorHandler, len(failedEntries), err)
+		return
+	}
+
+	w.spoolOrDrop(failedEntries, err)
+}
+
+// returns the entries which could not be sent, and why
+func (w *batchWriter) sendWithRetries(entries [][]byte) ([][]byte, error) {
+	var err error
+
+	for attempt := 0; ; attempt++ {
+		if err = w.send(entries); err == nil {
+			return nil, nil
+		}
+
+		// only retry what failed
+		var partialErr *partialSendError
+		if errors.As(err, &partialErr) {
+			entries = partialErr.entries
+		}
+
+		var permanentErr *permanentError
+		if errors.As(err, &permanentErr) || attempt >= w.config.Backoff.MaxRetries {
+			return entries, err
+		}
+
+		select {
+		case <-time.After(getBackoffInterval(w.config.Backoff, attempt)):
+		case <-w.closingChan:
+			return entries, err
+		}
+	}
+}
+
+func (w *batchWriter) spoolOrDrop(entries [][]byte, sendErr error) {
+	if len(entries) == 0 {
+		return
+	}
+
+	if w.spool != nil {
+		spoolErr := w.spool.push(entries)
+		if spoolErr == nil {
+			return
+		}
+
+		sendErr = fmt.Errorf("%v (and failed to spool, %v)", sendErr, spoolErr)
+	}
+
+	reportDroppedEntries(w.config.ErrorHandler, len(entries), sendErr)
+}
+
+func reportDroppedEntries(errorHandler func(error), entryCount int, err error) {
+	if errorHandler != nil {
+		errorHandler(fmt.Errorf("dropped %d log entries, %w", entryCount, err))
+	}
+}
+
+// sends spooled batches, oldest first, stopping at the first failure
+func (w *batchWriter) drainSpool() {
+	if w.spool == nil {
+		return
+	}
+
+	for {
+		path, entries, err := w.spool.peek()
+		if err != nil || path == "" {
+			return
+		}
+
+		if err := w.send(entries); err != nil {
+			var permanentErr *permanentError
+			var partialErr *partialSendError
+
+			switch {
+			case errors.As(err, &partialErr):
+
+				// keep only what failed in place (ahead of the batches spooled since), and try again later
+				w.spool.replace(path, partialErr.entries) // nolint: errcheck
+				return
+			case !errors.As(err, &permanentErr):
+				return
+			}
+
+			// sending a rejected batch again would only get it rejected again
+			reportDroppedEntries(w.config.ErrorHandler, len(entries), err)
+		}
+
+		w.spool.remove(path)
+	}
+}
+
+func populateBackoffConfigDefaults(config BackoffConfig) BackoffConfig {
+	if config.InitialInterval == 0 {
+		config.InitialInterval = 100 * time.Millisecond
+	}
+
+	if config.MaxInterval == 0 {
+		config.MaxInterval = 10 * time.Second
+	}
+
+	if config.Multiplier == 0 {
+		config.Multiplier = 2
+	}
+
+	if config.MaxRetries == 0 {
+		config.MaxRetries = 5
+	}
+
+	return config
+}
+
+// exponential backoff with +/- 50% jitter, so that many senders don't retry in lockstep
+func getBackoffInterval(config BackoffConfig, attempt int) time.Duration {
+	interval := float64(config.InitialInterval) * math.Pow(config.Multiplier, float64(attempt))
+	interval = math.Min(interval, float64(config.MaxInterval))
+
+	return time.Duration(interval * (0.5 + rand.Float64())) // nolint: gosec
+}
+
+// a bounded directory of batches, one file per batch
+type diskSpool struct {
+	dir      string
+	maxBytes int64
+	lock     sync.Mutex
+	sequence int
+}
+
+const diskSpoolFileExtension = ".spool"
+
+func newDiskSpool(dir string, maxBytes int64) (*diskSpool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory, %w", err)
+	}
+
+	return &diskSpool{
+		dir:      dir,
+		maxBytes: maxBytes,
+	}, nil
+}
+
+func (s *diskSpool) push(entries [][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// names sort by creation order, also across restarts
+	s.sequence++
+	fileName := fmt.Sprintf("%020d-%06d%s", time.Now().UnixNano(), s.sequence%1000000, diskSpoolFileExtension)
+
+	if err := s.writeFile(filepath.Join(s.dir, fileName), entries); err != nil {
+		return err
+	}
+
+	s.enforceMaxBytes()
+
+	return nil
+}
+
+// replaces the entries of a spooled batch, keeping its place in the spool
+func (s *diskSpool) replace(path string, entries [][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.writeFile(path, entries)
+}
+
+// must be called with the lock held. the file is replaced atomically, so a crash never leaves it truncated
+func (s *diskSpool) writeFile(path string, entries [][]byte) error {
+	temporaryPath := path + ".tmp"
+
+	file, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+
+	// each entry is prefixed by its length, since entries may be binary
+	fileWriter := bufio.NewWriter(file)
+	lengthBuffer := make([]byte, binary.MaxVarintLen64)
+	for _, entry := range entries {
+		lengthSize := binary.PutUvarint(lengthBuffer, uint64(len(entry)))
+		fileWriter.Write(lengthBuffer[:lengthSize]) // nolint: errcheck
+		fileWriter.Write(entry)                     // nolint: errcheck
+	}
+
+	if err := fileWriter.Flush(); err != nil {
+		file.Close()             // nolint: errcheck
+		os.Remove(temporaryPath) // nolint: errcheck
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(temporaryPath) // nolint: errcheck
+		return err
+	}
+
+	return os.Rename(temporaryPath, path)
+}
+
+// returns the oldest batch in the spool, or an empty path if there is none
+func (s *diskSpool) peek() (string, [][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	fileInfos, err := s.getFiles()
+	if err != nil || len(fileInfos) == 0 {
+		return "", nil, err
+	}
+
+	path := filepath.Join(s.dir, fileInfos[0].Name())
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var entries [][]byte
+	reader := bytes.NewReader(contents)
+	for {
+		length, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			break
+		}
+
+		entry := make([]byte, length)
+		if err == nil {
+			_, err = io.ReadFull(reader, entry)
+		}
+
+		// a corrupt file will never become readable - discard it
+		if err != nil {
+			os.Remove(path) // nolint: errcheck
+			return "", nil, fmt.Errorf("discarded corrupt spool file %s, %w", path, err)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return path, entries, nil
+}
+
+func (s *diskSpool) remove(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	os.Remove(path) // nolint: errcheck
+}
+
+// must be called with the lock held
+func (s *diskSpool) enforceMaxBytes() {
+	fileInfos, err := s.getFiles()
+	if err != nil {
+		return
+	}
+
+	var totalBytes int64
+	for _, fileInfo := range fileInfos {
+		totalBytes += fileInfo.Size()
+	}
+
+	for _, fileInfo := range fileInfos {
+		if totalBytes <= s.maxBytes {
+			return
+		}
+
+		os.Remove(filepath.Join(s.dir, fileInfo.Name())) // nolint: errcheck
+		totalBytes -= fileInfo.Size()
+	}
+}
+
+// returns the spooled batch files, oldest first
+func (s *diskSpool) getFiles() ([]os.FileInfo, error) {
+	fileInfos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var spoolFileInfos []os.FileInfo
+	for _, fileInfo := range fileInfos {
+		if !fileInfo.IsDir() && strings.HasSuffix(fileInfo.Name(), diskSpoolFileExtension) {
+			spoolFileInfos = append(spoolFileInfos, fileInfo)
+		}
+	}
+
+	sort.Slice(spoolFileInfos, func(i, j int) bool {
+		return spoolFileInfos[i].Name() < spoolFileInfos[j].Name()
+	})
+
+	return spoolFileInfos, nil
+}
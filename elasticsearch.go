@@ -0,0 +1,285 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const elasticsearchTimestampFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+type ElasticsearchWriterConfig struct {
+
+	// base URL of the cluster (e.g. http://elasticsearch:9200)
+	URL string
+
+	// documents are indexed to "<IndexPrefix>-<date>" (defaults to "loggerus")
+	IndexPrefix string
+
+	// Go time layout of the index date, from the entry's timestamp in UTC (defaults to "2006.01.02")
+	IndexDateFormat string
+
+	// bulk operation - "index", or "create" for data streams (defaults to "index")
+	OpType string
+
+	// renames JSONFormatter fields in the document. a field mapped to "" is omitted, unmapped fields
+	// are kept as is (defaults to when -> @timestamp, who -> logger, severity -> level, what -> message,
+	// more -> fields, and ctx omitted)
+	DocumentMapping map[string]string
+
+	// basic authentication credentials, if set
+	Username string
+	Password string
+
+	// API key authentication (base64 of id:key), if set
+	APIKey string
+
+	// additional headers sent with each request
+	Headers map[string]string
+
+	// timeout of a single request (defaults to 10 seconds, ignored if Client is set)
+	Timeout time.Duration
+
+	// client with which requests are sent
+	Client *http.Client
+
+	Batch BatchConfig
+}
+
+// an io.Writer indexing JSONFormatter formatted entries through the bulk API of Elasticsearch / OpenSearch.
+// only the documents which failed retryably are retried
+type ElasticsearchWriter struct {
+	*batchWriter
+	config ElasticsearchWriterConfig
+	client *http.Client
+}
+
+func NewElasticsearchWriter(config ElasticsearchWriterConfig) (*ElasticsearchWriter, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("Elasticsearch writer requires a URL")
+	}
+
+	if config.IndexPrefix == "" {
+		config.IndexPrefix = "loggerus"
+	}
+
+	if config.IndexDateFormat == "" {
+		config.IndexDateFormat = "2006.01.02"
+	}
+
+	if config.OpType == "" {
+		config.OpType = "index"
+	}
+
+	if config.DocumentMapping == nil {
+		config.DocumentMapping = map[string]string{
+			"when":     "@timestamp",
+			"who":      "logger",
+			"severity": "level",
+			"what":     "message",
+			"more":     "fields",
+			"ctx":      "",
+		}
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+
+	newElasticsearchWriter := ElasticsearchWriter{
+		config: config,
+		client: client,
+	}
+
+	batchWriterInstance, err := newBatchWriter(config.Batch, newElasticsearchWriter.sendEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	newElasticsearchWriter.batchWriter = batchWriterInstance
+
+	return &newElasticsearchWriter, nil
+}
+
+type elasticsearchBulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Status int             `json:"status"`
+		Error  json.RawMessage `json:"error"`
+	} `json:"items"`
+}
+
+func (w *ElasticsearchWriter) sendEntries(entries [][]byte) error {
+	body := bytes.Buffer{}
+
+	// entries which can't be mapped are dropped rather than failing the batch
+	var sentEntries [][]byte
+	for _, entry := range entries {
+		index, document, err := w.getDocument(entry)
+		if err != nil {
+			reportDroppedEntries(w.config.Batch.ErrorHandler, 1, fmt.Errorf("failed to map log entry to a document, %w", err))
+			continue
+		}
+
+		action, _ := json.Marshal(map[string]map[string]string{w.config.OpType: {"_index": index}})
+		body.Write(action)   // nolint: errcheck
+		body.WriteByte('\n') // nolint: errcheck
+		body.Write(document) // nolint: errcheck
+		body.WriteByte('\n') // nolint: errcheck
+
+		sentEntries = append(sentEntries, entry)
+	}
+
+	if len(sentEntries) == 0 {
+		return nil
+	}
+
+	responseBody, err := sendHTTPRequestWithResponse(w.client,
+		http.MethodPost,
+		strings.TrimSuffix(w.config.URL, "/")+"/_bulk",
+		&body,
+		func(request *http.Request) {
+			request.Header.Set("Content-Type", "application/x-ndjson")
+			setHTTPRequestAuthentication(request, w.config.Username, w.config.Password, "")
+
+			if w.config.APIKey != "" {
+				request.Header.Set("Authorization", "ApiKey "+w.config.APIKey)
+			}
+
+			for headerName, headerValue := range w.config.Headers {
+				request.Header.Set(headerName, headerValue)
+			}
+		})
+	if err != nil {
+		return err
+	}
+
+	return w.getBulkResponseError(sentEntries, responseBody)
+}
+
+// returns a partialSendError holding the entries whose documents failed retryably, if any
+func (w *ElasticsearchWriter) getBulkResponseError(entries [][]byte, responseBody []byte) error {
+	bulkResponse := elasticsearchBulkResponse{}
+	if err := json.Unmarshal(responseBody, &bulkResponse); err != nil {
+		return &permanentError{err: fmt.Errorf("failed to decode bulk response, %w", err)}
+	}
+
+	if !bulkResponse.Errors {
+		return nil
+	}
+
+	if len(bulkResponse.Items) != len(entries) {
+		return &permanentError{
+			err: fmt.Errorf("bulk response has %d items for %d documents", len(bulkResponse.Items), len(entries)),
+		}
+	}
+
+	var failedEntries [][]byte
+	var lastError string
+	for itemIndex, item := range bulkResponse.Items {
+		for _, itemResult := range item {
+			switch {
+			case itemResult.Status < 300:
+			case itemResult.Status == http.StatusTooManyRequests || itemResult.Status >= 500:
+				failedEntries = append(failedEntries, entries[itemIndex])
+				lastError = string(itemResult.Error)
+			default:
+
+				// e.g. a mapping conflict - sending it again would fail again
+				reportDroppedEntries(w.config.Batch.ErrorHandler,
+					1,
+					fmt.Errorf("failed to index log entry, status %d, %s", itemResult.Status, string(itemResult.Error)))
+			}
+		}
+	}
+
+	if len(failedEntries) == 0 {
+		return nil
+	}
+
+	return &partialSendError{
+		entries: failedEntries,
+		err:     fmt.Errorf("bulk indexing failed, %s", lastError),
+	}
+}
+
+// returns the index and document of a JSONFormatter formatted entry
+func (w *ElasticsearchWriter) getDocument(entry []byte) (string, []byte, error) {
+	fields := map[string]interface{}{}
+	if err := json.Unmarshal(entry, &fields); err != nil {
+		return "", nil, err
+	}
+
+	indexTime := time.Now()
+	if when, ok := fields["when"].(string); ok {
+		for _, timestampFormat := range []string{elasticsearchTimestampFormat, jsonDefaultTimestampFormat} {
+			if parsedTime, err := time.ParseInLocation(timestampFormat, when, time.Local); err == nil {
+				indexTime = parsedTime
+				break
+			}
+		}
+	}
+
+	document := make(map[string]interface{}, len(fields))
+	for fieldKey, fieldValue := range fields {
+		documentKey, mapped := w.config.DocumentMapping[fieldKey]
+		if !mapped {
+			documentKey = fieldKey
+		}
+
+		if documentKey != "" {
+			document[documentKey] = fieldValue
+		}
+	}
+
+	serializedDocument, err := json.Marshal(document)
+	if err != nil {
+		return "", nil, err
+	}
+
+	index := w.config.IndexPrefix + "-" + indexTime.UTC().Format(w.config.IndexDateFormat)
+
+	return index, serializedDocument, nil
+}
+
+// NewElasticsearchLoggerus creates a logger which indexes entries to Elasticsearch / OpenSearch
+func NewElasticsearchLoggerus(name string, level logrus.Level, config ElasticsearchWriterConfig) (*Loggerus, error) {
+	elasticsearchWriter, err := NewElasticsearchWriter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// timestamps with a timezone, so that they're indexed as dates correctly
+	loggerJSONFormatter, err := newJSONFormatter(elasticsearchTimestampFormat, "utc")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLoggerus(name, level, elasticsearchWriter, loggerJSONFormatter)
+}
@@ -0,0 +1,161 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type elasticsearchBulkRequest struct {
+	actions   []map[string]map[string]string
+	documents []map[string]interface{}
+}
+
+type elasticsearchSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	lock     sync.Mutex
+	requests []elasticsearchBulkRequest
+
+	// message -> statuses to respond with, in order (201 once exhausted)
+	itemStatuses map[string][]int
+}
+
+func (suite *elasticsearchSuite) SetupTest() {
+	suite.requests = nil
+	suite.itemStatuses = map[string][]int{}
+
+	suite.server = httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		suite.Require().Equal("/_bulk", request.URL.Path)
+
+		bulkRequest := elasticsearchBulkRequest{}
+		scanner := bufio.NewScanner(request.Body)
+		for scanner.Scan() {
+			action := map[string]map[string]string{}
+			suite.Require().NoError(json.Unmarshal(scanner.Bytes(), &action))
+			suite.Require().True(scanner.Scan())
+
+			document := map[string]interface{}{}
+			suite.Require().NoError(json.Unmarshal(scanner.Bytes(), &document))
+
+			bulkRequest.actions = append(bulkRequest.actions, action)
+			bulkRequest.documents = append(bulkRequest.documents, document)
+		}
+
+		suite.lock.Lock()
+		defer suite.lock.Unlock()
+
+		suite.requests = append(suite.requests, bulkRequest)
+
+		var items []string
+		hasErrors := false
+		for _, document := range bulkRequest.documents {
+			status := 201
+			message := document["message"].(string)
+			if statuses := suite.itemStatuses[message]; len(statuses) > 0 {
+				status = statuses[0]
+				suite.itemStatuses[message] = statuses[1:]
+			}
+
+			if status >= 300 {
+				hasErrors = true
+			}
+
+			items = append(items, fmt.Sprintf(`{"index":{"status":%d,"error":{"type":"x"}}}`, status))
+		}
+
+		fmt.Fprintf(responseWriter, `{"errors":%v,"items":[%s]}`, hasErrors, strings.Join(items, ",")) // nolint: errcheck
+	}))
+}
+
+func (suite *elasticsearchSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *elasticsearchSuite) TestIndexWithMapping() {
+	loggerInstance := suite.createLogger()
+
+	loggerInstance.InfoWith("Indexed", "key", "value")
+	suite.Require().NoError(loggerInstance.GetOutput().(*ElasticsearchWriter).Close())
+
+	suite.Require().Len(suite.requests, 1)
+
+	expectedIndex := "nuclio-" + time.Now().UTC().Format("2006.01.02")
+	suite.Require().Equal(map[string]map[string]string{"index": {"_index": expectedIndex}},
+		suite.requests[0].actions[0])
+
+	document := suite.requests[0].documents[0]
+	suite.Require().Equal("Indexed", document["message"])
+	suite.Require().Equal("test", document["logger"])
+	suite.Require().Equal("INFO", document["level"])
+	suite.Require().Equal(map[string]interface{}{"key": "value"}, document["fields"])
+	suite.Require().NotContains(document, "ctx")
+
+	timestamp, err := time.Parse(time.RFC3339Nano, document["@timestamp"].(string))
+	suite.Require().NoError(err)
+	suite.Require().WithinDuration(time.Now(), timestamp, time.Minute)
+}
+
+func (suite *elasticsearchSuite) TestRetryOnlyFailedItems() {
+	suite.itemStatuses["Throttled"] = []int{429, 503}
+	suite.itemStatuses["Conflicting"] = []int{400}
+
+	loggerInstance := suite.createLogger()
+	defer loggerInstance.GetOutput().(*ElasticsearchWriter).Close() // nolint: errcheck
+
+	loggerInstance.InfoWith("Fine")
+	loggerInstance.InfoWith("Throttled")
+	loggerInstance.InfoWith("Conflicting")
+	suite.Require().NoError(loggerInstance.GetOutput().(*ElasticsearchWriter).Flush())
+
+	// the throttled document is retried alone until indexed, the conflicting one is dropped
+	suite.Require().Len(suite.requests, 3)
+	suite.Require().Len(suite.requests[0].documents, 3)
+	for _, request := range suite.requests[1:] {
+		suite.Require().Len(request.documents, 1)
+		suite.Require().Equal("Throttled", request.documents[0]["message"])
+	}
+}
+
+func (suite *elasticsearchSuite) createLogger() *Loggerus {
+	loggerInstance, err := NewElasticsearchLoggerus("test", logrus.InfoLevel, ElasticsearchWriterConfig{
+		URL:         suite.server.URL,
+		IndexPrefix: "nuclio",
+		Batch: BatchConfig{
+			FlushInterval: time.Hour,
+			Backoff:       BackoffConfig{InitialInterval: time.Millisecond},
+		},
+	})
+	suite.Require().NoError(err)
+
+	return loggerInstance
+}
+
+func TestElasticsearchTestSuite(t *testing.T) {
+	suite.Run(t, new(elasticsearchSuite))
+}
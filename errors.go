@@ -0,0 +1,90 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"errors"
+	"strings"
+)
+
+// implemented by the errors returned by Flush and Close when several loggers or outputs failed
+type MultiError interface {
+	error
+
+	// the aggregated errors
+	Errors() []error
+}
+
+// the errors of an operation applied to several loggers or outputs (e.g. closing them)
+type multiError struct {
+	errs []error
+}
+
+// returns nil if there are no errors, and the error itself if there's one
+func newMultiError(errs []error) error {
+	var nonNilErrs []error
+	for _, err := range errs {
+		if err != nil {
+			nonNilErrs = append(nonNilErrs, err)
+		}
+	}
+
+	switch len(nonNilErrs) {
+	case 0:
+		return nil
+	case 1:
+		return nonNilErrs[0]
+	default:
+		return &multiError{errs: nonNilErrs}
+	}
+}
+
+func (e *multiError) Error() string {
+	messages := make([]string, 0, len(e.errs))
+	for _, err := range e.errs {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Errors returns the aggregated errors
+func (e *multiError) Errors() []error {
+	return e.errs
+}
+
+// Is reports whether any of the aggregated errors matches the target, so that errors.Is looks into them
+func (e *multiError) Is(target error) bool {
+	for _, err := range e.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// As finds the first of the aggregated errors matching the target, so that errors.As looks into them
+func (e *multiError) As(target interface{}) bool {
+	for _, err := range e.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,634 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type FluentFormatterConfig struct {
+
+	// prepended to the logger name to form the tag (e.g. "nuclio" yields "nuclio.controller.worker")
+	TagPrefix string
+
+	// tag of entries without a logger name (defaults to TagPrefix, or "loggerus" if that is empty too)
+	DefaultTag string
+}
+
+// formats entries as Fluent forward protocol messages ([tag, time, record]), for FluentWriter.
+// the record follows the JSONFormatter schema (who, severity, what and more)
+type FluentFormatter struct {
+	config FluentFormatterConfig
+}
+
+func NewFluentFormatter(config FluentFormatterConfig) (*FluentFormatter, error) {
+	if config.DefaultTag == "" {
+		config.DefaultTag = config.TagPrefix
+	}
+
+	if config.DefaultTag == "" {
+		config.DefaultTag = "loggerus"
+	}
+
+	return &FluentFormatter{
+		config: config,
+	}, nil
+}
+
+func (f *FluentFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	tag := f.config.DefaultTag
+	if who, ok := entry.Data["who"].(string); ok && who != "" {
+		tag = who
+		if f.config.TagPrefix != "" {
+			tag = f.config.TagPrefix + "." + who
+		}
+	}
+
+	more := map[string]interface{}{}
+	for fieldKey, fieldValue := range entry.Data {
+		switch fieldKey {
+		case "who", "ctx":
+		default:
+			more[fieldKey] = fieldValue
+		}
+	}
+
+	message := appendMsgpackArrayHeader(nil, 3)
+	message = appendMsgpackString(message, tag)
+	message = appendMsgpackEventTime(message, entry.Time)
+	message = appendMsgpackMapHeader(message, 4)
+	message = appendMsgpackString(message, "who")
+	message = appendMsgpackValue(message, entry.Data["who"])
+	message = appendMsgpackString(message, "severity")
+	message = appendMsgpackString(message, strings.ToUpper(entry.Level.String()))
+	message = appendMsgpackString(message, "what")
+	message = appendMsgpackString(message, entry.Message)
+	message = appendMsgpackString(message, "more")
+	message = appendMsgpackValue(message, more)
+
+	return message, nil
+}
+
+type FluentWriterConfig struct {
+
+	// "tcp" or "unix" (defaults to tcp)
+	Network string
+
+	// host:port, or a socket path for unix
+	Address string
+
+	// wait for the server to acknowledge each chunk, resending it if it doesn't
+	RequireAck bool
+
+	// timeout for connecting (defaults to 5 seconds)
+	DialTimeout time.Duration
+
+	// timeout for writing a chunk and receiving its ack (defaults to 10 seconds)
+	Timeout time.Duration
+
+	Batch BatchConfig
+}
+
+// an io.Writer sending batches of FluentFormatter formatted entries over the Fluent forward protocol,
+// in PackedForward mode. entries are buffered (and spooled, if configured) while disconnected
+type FluentWriter struct {
+	*batchWriter
+	config FluentWriterConfig
+	conn   net.Conn
+}
+
+func NewFluentWriter(config FluentWriterConfig) (*FluentWriter, error) {
+	if config.Network == "" {
+		config.Network = "tcp"
+	}
+
+	if config.Address == "" {
+		return nil, fmt.Errorf("Fluent writer requires an address")
+	}
+
+	if config.DialTimeout == 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	newFluentWriter := FluentWriter{
+		config: config,
+	}
+
+	batchWriterInstance, err := newBatchWriter(config.Batch, newFluentWriter.sendEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	newFluentWriter.batchWriter = batchWriterInstance
+
+	return &newFluentWriter, nil
+}
+
+// Close sends pending entries and closes the connection
+func (w *FluentWriter) Close() error {
+	err := w.batchWriter.Close()
+
+	// the send loop has exited, nothing else touches the connection
+	w.closeConn()
+
+	return err
+}
+
+// called from the batch writer's goroutine only
+func (w *FluentWriter) sendEntries(entries [][]byte) error {
+	var tags []string
+	entryStreams := map[string][]byte{}
+	entriesByTag := map[string][][]byte{}
+
+	// group by tag, keeping each entry's [time, record]. entries which can't be split are dropped rather than
+	// failing the batch
+	for _, entry := range entries {
+		tag, eventEntry, err := splitFluentMessage(entry)
+		if err != nil {
+			reportDroppedEntries(w.config.Batch.ErrorHandler, 1, err)
+			continue
+		}
+
+		if _, found := entryStreams[tag]; !found {
+			tags = append(tags, tag)
+		}
+
+		entryStreams[tag] = append(entryStreams[tag], eventEntry...)
+		entriesByTag[tag] = append(entriesByTag[tag], entry)
+	}
+
+	for tagIndex, tag := range tags {
+		if err := w.sendPackedForward(tag, entryStreams[tag], len(entriesByTag[tag])); err != nil {
+			w.closeConn()
+
+			// the chunks sent before were acknowledged - only the rest are retried
+			var failedEntries [][]byte
+			for _, failedTag := range tags[tagIndex:] {
+				failedEntries = append(failedEntries, entriesByTag[failedTag]...)
+			}
+
+			return &partialSendError{entries: failedEntries, err: err}
+		}
+	}
+
+	return nil
+}
+
+// [tag, <entries as bin>, {"size": n, "chunk": id}]
+func (w *FluentWriter) sendPackedForward(tag string, entryStream []byte, entryCount int) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.config.Network, w.config.Address, w.config.DialTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s, %w", w.config.Address, err)
+		}
+
+		w.conn = conn
+	}
+
+	optionCount := 1
+	chunkID := ""
+	if w.config.RequireAck {
+		optionCount++
+		chunkID = generateFluentChunkID()
+	}
+
+	message := appendMsgpackArrayHeader(nil, 3)
+	message = appendMsgpackString(message, tag)
+	message = appendMsgpackBinary(message, entryStream)
+	message = appendMsgpackMapHeader(message, optionCount)
+	message = appendMsgpackString(message, "size")
+	message = appendMsgpackValue(message, entryCount)
+	if w.config.RequireAck {
+		message = appendMsgpackString(message, "chunk")
+		message = appendMsgpackString(message, chunkID)
+	}
+
+	if err := w.conn.SetDeadline(time.Now().Add(w.config.Timeout)); err != nil {
+		return err
+	}
+
+	if _, err := w.conn.Write(message); err != nil {
+		return fmt.Errorf("failed to write to %s, %w", w.config.Address, err)
+	}
+
+	if !w.config.RequireAck {
+		return nil
+	}
+
+	response, err := decodeMsgpackValue(bufio.NewReader(w.conn))
+	if err != nil {
+		return fmt.Errorf("failed to read ack from %s, %w", w.config.Address, err)
+	}
+
+	responseMap, ok := response.(map[string]interface{})
+	if !ok || responseMap["ack"] != chunkID {
+		return fmt.Errorf("unexpected ack from %s: %v", w.config.Address, response)
+	}
+
+	return nil
+}
+
+func (w *FluentWriter) closeConn() {
+	if w.conn != nil {
+		w.conn.Close() // nolint: errcheck
+		w.conn = nil
+	}
+}
+
+// splits a FluentFormatter message ([tag, time, record]) to its tag and a [time, record] entry
+func splitFluentMessage(message []byte) (string, []byte, error) {
+	if len(message) == 0 || message[0] != 0x93 {
+		return "", nil, errors.New("entry is not a Fluent message (was it formatted with FluentFormatter?)")
+	}
+
+	reader := bytes.NewReader(message[1:])
+	tag, err := decodeMsgpackValue(reader)
+	if err != nil {
+		return "", nil, err
+	}
+
+	tagString, ok := tag.(string)
+	if !ok {
+		return "", nil, errors.New("Fluent message tag is not a string")
+	}
+
+	eventEntry := append([]byte{0x92}, message[len(message)-reader.Len():]...)
+
+	return tagString, eventEntry, nil
+}
+
+func generateFluentChunkID() string {
+	chunkID := make([]byte, 16)
+	rand.Read(chunkID) // nolint: errcheck
+
+	return base64.StdEncoding.EncodeToString(chunkID)
+}
+
+// NewFluentLoggerus creates a logger which sends entries to Fluentd / Fluent Bit over the forward protocol
+func NewFluentLoggerus(name string,
+	level logrus.Level,
+	formatterConfig FluentFormatterConfig,
+	writerConfig FluentWriterConfig) (*Loggerus, error) {
+
+	fluentFormatter, err := NewFluentFormatter(formatterConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	fluentWriter, err := NewFluentWriter(writerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLoggerus(name, level, fluentWriter, fluentFormatter)
+}
+
+//
+// MessagePack - just what the forward protocol requires
+//
+
+func appendMsgpackArrayHeader(buffer []byte, length int) []byte {
+	switch {
+	case length < 16:
+		return append(buffer, 0x90|byte(length))
+	case length <= math.MaxUint16:
+		return append(buffer, 0xdc, byte(length>>8), byte(length))
+	default:
+		return append(buffer, 0xdd, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	}
+}
+
+func appendMsgpackMapHeader(buffer []byte, length int) []byte {
+	switch {
+	case length < 16:
+		return append(buffer, 0x80|byte(length))
+	case length <= math.MaxUint16:
+		return append(buffer, 0xde, byte(length>>8), byte(length))
+	default:
+		return append(buffer, 0xdf, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	}
+}
+
+func appendMsgpackString(buffer []byte, value string) []byte {
+	length := len(value)
+
+	switch {
+	case length < 32:
+		buffer = append(buffer, 0xa0|byte(length))
+	case length <= math.MaxUint8:
+		buffer = append(buffer, 0xd9, byte(length))
+	case length <= math.MaxUint16:
+		buffer = append(buffer, 0xda, byte(length>>8), byte(length))
+	default:
+		buffer = append(buffer, 0xdb, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	}
+
+	return append(buffer, value...)
+}
+
+func appendMsgpackBinary(buffer []byte, value []byte) []byte {
+	length := len(value)
+
+	switch {
+	case length <= math.MaxUint8:
+		buffer = append(buffer, 0xc4, byte(length))
+	case length <= math.MaxUint16:
+		buffer = append(buffer, 0xc5, byte(length>>8), byte(length))
+	default:
+		buffer = append(buffer, 0xc6, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	}
+
+	return append(buffer, value...)
+}
+
+// EventTime extension (type 0): seconds and nanoseconds as big endian uint32s
+func appendMsgpackEventTime(buffer []byte, eventTime time.Time) []byte {
+	buffer = append(buffer, 0xd7, 0x00)
+	buffer = appendUint32(buffer, uint32(eventTime.Unix()))
+	return appendUint32(buffer, uint32(eventTime.Nanosecond()))
+}
+
+func appendMsgpackValue(buffer []byte, value interface{}) []byte {
+	switch typedValue := value.(type) {
+	case nil:
+		return append(buffer, 0xc0)
+	case bool:
+		if typedValue {
+			return append(buffer, 0xc3)
+		}
+		return append(buffer, 0xc2)
+	case string:
+		return appendMsgpackString(buffer, typedValue)
+	case []byte:
+		return appendMsgpackBinary(buffer, typedValue)
+	case int, int8, int16, int32, int64:
+		return appendMsgpackInt(buffer, reflect.ValueOf(typedValue).Int())
+	case uint, uint8, uint16, uint32, uint64:
+		buffer = append(buffer, 0xcf)
+		return appendUint64(buffer, reflect.ValueOf(typedValue).Uint())
+	case float32:
+		return appendMsgpackFloat(buffer, float64(typedValue))
+	case float64:
+		return appendMsgpackFloat(buffer, typedValue)
+	case map[string]interface{}:
+		buffer = appendMsgpackMapHeader(buffer, len(typedValue))
+		for mapKey, mapValue := range typedValue {
+			buffer = appendMsgpackString(buffer, mapKey)
+			buffer = appendMsgpackValue(buffer, mapValue)
+		}
+		return buffer
+	default:
+
+		// other map types with string keys (e.g. logrus.Fields) are maps too
+		reflectedValue := reflect.ValueOf(value)
+		if reflectedValue.Kind() == reflect.Map && reflectedValue.Type().Key().Kind() == reflect.String {
+			buffer = appendMsgpackMapHeader(buffer, reflectedValue.Len())
+			for mapIterator := reflectedValue.MapRange(); mapIterator.Next(); {
+				buffer = appendMsgpackString(buffer, mapIterator.Key().String())
+				buffer = appendMsgpackValue(buffer, mapIterator.Value().Interface())
+			}
+			return buffer
+		}
+
+		// errors, structs, slices and such are rendered as in the other formatters
+		return appendMsgpackString(buffer, getFieldValueString(value))
+	}
+}
+
+func appendMsgpackInt(buffer []byte, value int64) []byte {
+	if value >= 0 && value < 128 {
+		return append(buffer, byte(value))
+	}
+
+	buffer = append(buffer, 0xd3)
+	return appendUint64(buffer, uint64(value))
+}
+
+func appendMsgpackFloat(buffer []byte, value float64) []byte {
+	buffer = append(buffer, 0xcb)
+	return appendUint64(buffer, math.Float64bits(value))
+}
+
+func appendUint32(buffer []byte, value uint32) []byte {
+	encoded := make([]byte, 4)
+	binary.BigEndian.PutUint32(encoded, value)
+	return append(buffer, encoded...)
+}
+
+func appendUint64(buffer []byte, value uint64) []byte {
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, value)
+	return append(buffer, encoded...)
+}
+
+type msgpackReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// msgpackExtension holds a decoded extension value (e.g. EventTime)
+type msgpackExtension struct {
+	Type int8
+	Data []byte
+}
+
+// decodes a single value. maps are decoded to map[string]interface{} (non string keys are formatted),
+// integers to int64 / uint64 and binaries to []byte
+func decodeMsgpackValue(reader msgpackReader) (interface{}, error) {
+	typeByte, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case typeByte <= 0x7f:
+		return int64(typeByte), nil
+	case typeByte >= 0xe0:
+		return int64(int8(typeByte)), nil
+	case typeByte&0xf0 == 0x80:
+		return decodeMsgpackMap(reader, int(typeByte&0x0f))
+	case typeByte&0xf0 == 0x90:
+		return decodeMsgpackArray(reader, int(typeByte&0x0f))
+	case typeByte&0xe0 == 0xa0:
+		value, err := readMsgpackBytes(reader, int(typeByte&0x1f))
+		return string(value), err
+	}
+
+	switch typeByte {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		length, err := readMsgpackLength(reader, 1<<(typeByte-0xc4))
+		if err != nil {
+			return nil, err
+		}
+		return readMsgpackBytes(reader, length)
+	case 0xd9, 0xda, 0xdb:
+		length, err := readMsgpackLength(reader, 1<<(typeByte-0xd9))
+		if err != nil {
+			return nil, err
+		}
+		value, err := readMsgpackBytes(reader, length)
+		return string(value), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		value, err := readMsgpackBytes(reader, 1<<(typeByte-0xcc))
+		if err != nil {
+			return nil, err
+		}
+		return decodeBigEndian(value), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (typeByte - 0xd0)
+		value, err := readMsgpackBytes(reader, size)
+		if err != nil {
+			return nil, err
+		}
+
+		// sign extend
+		shift := uint(64 - size*8)
+		return int64(decodeBigEndian(value)<<shift) >> shift, nil
+	case 0xca:
+		value, err := readMsgpackBytes(reader, 4)
+		if err != nil {
+			return nil, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(value))), nil
+	case 0xcb:
+		value, err := readMsgpackBytes(reader, 8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(value)), nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return decodeMsgpackExtension(reader, 1<<(typeByte-0xd4))
+	case 0xc7, 0xc8, 0xc9:
+		length, err := readMsgpackLength(reader, 1<<(typeByte-0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackExtension(reader, length)
+	case 0xdc, 0xdd:
+		length, err := readMsgpackLength(reader, 2<<(typeByte-0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackArray(reader, length)
+	case 0xde, 0xdf:
+		length, err := readMsgpackLength(reader, 2<<(typeByte-0xde))
+		if err != nil {
+			return nil, err
+		}
+		return decodeMsgpackMap(reader, length)
+	}
+
+	return nil, fmt.Errorf("unsupported MessagePack type 0x%x", typeByte)
+}
+
+func decodeMsgpackMap(reader msgpackReader, length int) (map[string]interface{}, error) {
+	decodedMap := make(map[string]interface{}, length)
+
+	for index := 0; index < length; index++ {
+		key, err := decodeMsgpackValue(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		value, err := decodeMsgpackValue(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		decodedMap[fmt.Sprint(key)] = value
+	}
+
+	return decodedMap, nil
+}
+
+func decodeMsgpackArray(reader msgpackReader, length int) ([]interface{}, error) {
+	decodedArray := make([]interface{}, 0, length)
+
+	for index := 0; index < length; index++ {
+		value, err := decodeMsgpackValue(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		decodedArray = append(decodedArray, value)
+	}
+
+	return decodedArray, nil
+}
+
+func decodeMsgpackExtension(reader msgpackReader, length int) (*msgpackExtension, error) {
+	extensionType, err := reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readMsgpackBytes(reader, length)
+	if err != nil {
+		return nil, err
+	}
+
+	return &msgpackExtension{Type: int8(extensionType), Data: data}, nil
+}
+
+func readMsgpackLength(reader msgpackReader, size int) (int, error) {
+	lengthBytes, err := readMsgpackBytes(reader, size)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(decodeBigEndian(lengthBytes)), nil
+}
+
+func readMsgpackBytes(reader msgpackReader, length int) ([]byte, error) {
+	value := make([]byte, length)
+	_, err := io.ReadFull(reader, value)
+
+	return value, err
+}
+
+func decodeBigEndian(value []byte) uint64 {
+	var decoded uint64
+	for _, valueByte := range value {
+		decoded = decoded<<8 | uint64(valueByte)
+	}
+
+	return decoded
+}
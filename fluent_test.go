@@ -0,0 +1,252 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type fluentEvent struct {
+	tag    string
+	time   *msgpackExtension
+	record map[string]interface{}
+}
+
+type fluentSuite struct {
+	suite.Suite
+	listener   net.Listener
+	lock       sync.Mutex
+	events     []fluentEvent
+	options    []map[string]interface{}
+	chunkCount int
+	dropChunk  int
+}
+
+func (suite *fluentSuite) SetupTest() {
+	var err error
+
+	suite.events = nil
+	suite.options = nil
+	suite.chunkCount = 0
+	suite.dropChunk = 0
+
+	suite.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+
+	go func() {
+		for {
+			conn, err := suite.listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go suite.serveForward(conn)
+		}
+	}()
+}
+
+func (suite *fluentSuite) TearDownTest() {
+	suite.listener.Close() // nolint: errcheck
+}
+
+func (suite *fluentSuite) TestPackedForward() {
+	loggerInstance := suite.createLogger(false)
+
+	loggerInstance.InfoWith("First", "key", "value", "count", 3, "nested", logrus.Fields{"depth": 2})
+	loggerInstance.GetChild("worker").(*Loggerus).WarnWith("Second")
+	loggerInstance.InfoWith("Third")
+	suite.Require().NoError(loggerInstance.GetOutput().(*FluentWriter).Close())
+
+	suite.Require().Eventually(func() bool {
+		return len(suite.getEvents()) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	events := suite.getEvents()
+
+	// one chunk per tag, entries of a tag keep their order
+	suite.Require().Equal("nuclio.test", events[0].tag)
+	suite.Require().Equal("nuclio.test", events[1].tag)
+	suite.Require().Equal("nuclio.test.worker", events[2].tag)
+
+	suite.Require().Equal("First", events[0].record["what"])
+	suite.Require().Equal("INFO", events[0].record["severity"])
+	suite.Require().Equal("test", events[0].record["who"])
+	suite.Require().Equal(map[string]interface{}{
+		"key":    "value",
+		"count":  int64(3),
+		"nested": map[string]interface{}{"depth": int64(2)},
+	}, events[0].record["more"])
+	suite.Require().Equal("Third", events[1].record["what"])
+
+	// EventTime
+	suite.Require().Equal(int8(0), events[0].time.Type)
+	suite.Require().Len(events[0].time.Data, 8)
+
+	suite.lock.Lock()
+	suite.Require().Equal(int64(2), suite.options[0]["size"])
+	suite.lock.Unlock()
+}
+
+func (suite *fluentSuite) TestAckAndResend() {
+	loggerInstance := suite.createLogger(true)
+	defer loggerInstance.GetOutput().(*FluentWriter).Close() // nolint: errcheck
+
+	// the server swallows the first chunk without acking and disconnects - it must be resent
+	suite.lock.Lock()
+	suite.dropChunk = 1
+	suite.lock.Unlock()
+
+	loggerInstance.InfoWith("Acked")
+	suite.Require().NoError(loggerInstance.GetOutput().(*FluentWriter).Flush())
+
+	events := suite.getEvents()
+	suite.Require().Len(events, 1)
+	suite.Require().Equal("Acked", events[0].record["what"])
+}
+
+func (suite *fluentSuite) TestResendOnlyUnackedChunks() {
+	loggerInstance := suite.createLogger(true)
+	defer loggerInstance.GetOutput().(*FluentWriter).Close() // nolint: errcheck
+
+	// the chunk of the second tag isn't acked - the first was, so it must not be resent
+	suite.lock.Lock()
+	suite.dropChunk = 2
+	suite.lock.Unlock()
+
+	loggerInstance.InfoWith("First")
+	loggerInstance.GetChild("worker").(*Loggerus).InfoWith("Second")
+	suite.Require().NoError(loggerInstance.GetOutput().(*FluentWriter).Flush())
+
+	events := suite.getEvents()
+	suite.Require().Len(events, 2)
+	suite.Require().Equal("First", events[0].record["what"])
+	suite.Require().Equal("Second", events[1].record["what"])
+}
+
+func (suite *fluentSuite) TestUnsplittableEntryIsDropped() {
+	var droppedErrors []error
+	writer, err := NewFluentWriter(FluentWriterConfig{
+		Address: suite.listener.Addr().String(),
+		Batch: BatchConfig{
+			FlushInterval: time.Hour,
+			ErrorHandler: func(err error) {
+				droppedErrors = append(droppedErrors, err)
+			},
+		},
+	})
+	suite.Require().NoError(err)
+
+	formatter, err := NewFluentFormatter(FluentFormatterConfig{})
+	suite.Require().NoError(err)
+
+	loggerInstance, err := NewLoggerus("test", logrus.DebugLevel, writer, formatter)
+	suite.Require().NoError(err)
+
+	_, err = writer.Write([]byte("not formatted with FluentFormatter"))
+	suite.Require().NoError(err)
+	loggerInstance.InfoWith("Splittable")
+	suite.Require().NoError(writer.Close())
+
+	// the rest of the batch is still sent
+	suite.Require().Eventually(func() bool {
+		return len(suite.getEvents()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	suite.Require().Equal("Splittable", suite.getEvents()[0].record["what"])
+	suite.Require().Len(droppedErrors, 1)
+	suite.Require().Contains(droppedErrors[0].Error(), "is not a Fluent message")
+}
+
+func (suite *fluentSuite) createLogger(requireAck bool) *Loggerus {
+	loggerInstance, err := NewFluentLoggerus("test",
+		logrus.DebugLevel,
+		FluentFormatterConfig{TagPrefix: "nuclio"},
+		FluentWriterConfig{
+			Address:    suite.listener.Addr().String(),
+			RequireAck: requireAck,
+			Timeout:    time.Second,
+			Batch: BatchConfig{
+				FlushInterval: time.Hour,
+				Backoff:       BackoffConfig{InitialInterval: time.Millisecond},
+			},
+		})
+	suite.Require().NoError(err)
+
+	return loggerInstance
+}
+
+func (suite *fluentSuite) serveForward(conn net.Conn) {
+	defer conn.Close() // nolint: errcheck
+	reader := bufio.NewReader(conn)
+
+	for {
+		message, err := decodeMsgpackValue(reader)
+		if err != nil {
+			return
+		}
+
+		fields := message.([]interface{})
+		tag := fields[0].(string)
+		options := fields[2].(map[string]interface{})
+
+		suite.lock.Lock()
+		suite.chunkCount++
+		if suite.chunkCount == suite.dropChunk {
+			suite.lock.Unlock()
+			return
+		}
+
+		suite.options = append(suite.options, options)
+
+		entriesReader := bytes.NewReader(fields[1].([]byte))
+		for entriesReader.Len() > 0 {
+			entry, err := decodeMsgpackValue(entriesReader)
+			suite.Require().NoError(err)
+
+			entryFields := entry.([]interface{})
+			suite.events = append(suite.events, fluentEvent{
+				tag:    tag,
+				time:   entryFields[0].(*msgpackExtension),
+				record: entryFields[1].(map[string]interface{}),
+			})
+		}
+		suite.lock.Unlock()
+
+		if chunk, ok := options["chunk"]; ok {
+			conn.Write(appendMsgpackValue(nil, map[string]interface{}{"ack": chunk})) // nolint: errcheck
+		}
+	}
+}
+
+func (suite *fluentSuite) getEvents() []fluentEvent {
+	suite.lock.Lock()
+	defer suite.lock.Unlock()
+
+	return append([]fluentEvent{}, suite.events...)
+}
+
+func TestFluentTestSuite(t *testing.T) {
+	suite.Run(t, new(fluentSuite))
+}
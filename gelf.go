@@ -0,0 +1,375 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type GELFCompression int
+
+const (
+	GELFCompressionGzip GELFCompression = iota
+	GELFCompressionZlib
+	GELFCompressionNone
+)
+
+const (
+	gelfVersion         = "1.1"
+	gelfDefaultChunk    = 1420
+	gelfMaxChunks       = 128
+	gelfChunkHeaderSize = 12
+)
+
+var gelfInvalidFieldNameCharacters = regexp.MustCompile(`[^\w.\-]`)
+
+type GELFFormatterConfig struct {
+
+	// host field (defaults to os.Hostname())
+	Host string
+
+	// fields whose JSON exceeds this length are considered block fields, as in TextFormatter
+	// (0 means only multiline strings are)
+	MaxVariableLen int
+}
+
+// formats entries as GELF 1.1 messages (without framing)
+type GELFFormatter struct {
+	config GELFFormatterConfig
+}
+
+func NewGELFFormatter(config GELFFormatterConfig) (*GELFFormatter, error) {
+	if config.Host == "" {
+		config.Host, _ = os.Hostname()
+	}
+
+	if config.MaxVariableLen == 0 {
+		config.MaxVariableLen = math.MaxInt64
+	}
+
+	return &GELFFormatter{
+		config: config,
+	}, nil
+}
+
+func (f *GELFFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	message := map[string]interface{}{
+		"version":       gelfVersion,
+		"host":          f.config.Host,
+		"short_message": entry.Message,
+		"timestamp":     float64(entry.Time.UnixNano()/int64(time.Millisecond)) / 1000,
+		"level":         getSyslogSeverity(entry.Level),
+	}
+
+	if who, ok := entry.Data["who"].(string); ok && who != "" {
+		message["_who"] = who
+	}
+
+	var fieldKeys []string
+	for fieldKey := range entry.Data {
+		switch fieldKey {
+		case "who", "ctx":
+		default:
+			fieldKeys = append(fieldKeys, fieldKey)
+		}
+	}
+
+	sort.Strings(fieldKeys)
+
+	// every field is an additional field, and the ones which TextFormatter would write as blocks
+	// make up the full message
+	fullMessage := ""
+	for _, fieldKey := range fieldKeys {
+		fieldValue := entry.Data[fieldKey]
+
+		if blockOutput, isBlock := getFieldOutput(fieldValue, f.config.MaxVariableLen); isBlock {
+			fullMessage += fmt.Sprintf("* %s:\n%s\n", fieldKey, blockOutput)
+		}
+
+		message[getGELFAdditionalFieldName(fieldKey)] = getGELFAdditionalFieldValue(fieldValue)
+	}
+
+	if fullMessage != "" {
+		message["full_message"] = entry.Message + "\n" + fullMessage
+	}
+
+	serialized, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal GELF message, %v", err)
+	}
+
+	return serialized, nil
+}
+
+// additional field names are "_" followed by [\w.-], and "_id" is reserved
+func getGELFAdditionalFieldName(fieldKey string) string {
+	fieldName := "_" + gelfInvalidFieldNameCharacters.ReplaceAllString(fieldKey, "_")
+	if fieldName == "_id" {
+		fieldName = "_id_"
+	}
+
+	return fieldName
+}
+
+// additional field values are numbers or strings
+func getGELFAdditionalFieldValue(fieldValue interface{}) interface{} {
+	switch typedValue := fieldValue.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32:
+		return typedValue
+	case float64:
+		if math.IsNaN(typedValue) || math.IsInf(typedValue, 0) {
+			return fmt.Sprint(typedValue)
+		}
+		return typedValue
+	default:
+		return getFieldValueString(fieldValue)
+	}
+}
+
+type GELFWriterConfig struct {
+
+	// "udp" or "tcp"
+	Network string
+
+	// host:port
+	Address string
+
+	// compression of UDP messages (TCP messages are never compressed)
+	Compression GELFCompression
+
+	// maximum UDP datagram size, beyond which messages are chunked (defaults to 1420)
+	ChunkSize int
+
+	// timeout for connecting (defaults to 5 seconds)
+	DialTimeout time.Duration
+
+	// timeout for writing a single message (0 means no timeout)
+	WriteTimeout time.Duration
+}
+
+// an io.Writer sending each write as a GELF message - chunked over UDP, or null delimited over TCP
+type GELFWriter struct {
+	config GELFWriterConfig
+	lock   sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+func NewGELFWriter(config GELFWriterConfig) (*GELFWriter, error) {
+	switch config.Network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("unsupported GELF network %q", config.Network)
+	}
+
+	if config.ChunkSize == 0 {
+		config.ChunkSize = gelfDefaultChunk
+	}
+
+	if config.ChunkSize <= gelfChunkHeaderSize {
+		return nil, fmt.Errorf("GELF chunk size must be larger than %d", gelfChunkHeaderSize)
+	}
+
+	if config.DialTimeout == 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+
+	return &GELFWriter{
+		config: config,
+	}, nil
+}
+
+func (w *GELFWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	packets, err := w.getPackets(bytes.TrimRight(p, "\n"))
+	if err != nil {
+		return 0, err
+	}
+
+	// if the connection broke since the last write, reconnect and retry once
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = w.writePackets(packets); err == nil {
+			return len(p), nil
+		}
+
+		w.closeConn()
+	}
+
+	return 0, fmt.Errorf("failed to write to GELF input at %s, %w", w.config.Address, err)
+}
+
+// Close closes the connection to the GELF input
+func (w *GELFWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.closed = true
+
+	return w.closeConn()
+}
+
+func (w *GELFWriter) getPackets(message []byte) ([][]byte, error) {
+	if !strings.HasPrefix(w.config.Network, "udp") {
+
+		// the null byte delimits messages over TCP
+		return [][]byte{append(bytes.ReplaceAll(message, []byte{0}, nil), 0)}, nil
+	}
+
+	compressed, err := w.compress(message)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(compressed) <= w.config.ChunkSize {
+		return [][]byte{compressed}, nil
+	}
+
+	// 0x1e 0x0f, message id (8 bytes), sequence number, sequence count, payload
+	payloadSize := w.config.ChunkSize - gelfChunkHeaderSize
+	chunkCount := (len(compressed) + payloadSize - 1) / payloadSize
+	if chunkCount > gelfMaxChunks {
+		return nil, fmt.Errorf("GELF message of %d bytes exceeds %d chunks", len(compressed), gelfMaxChunks)
+	}
+
+	messageID := make([]byte, 8)
+	if _, err := rand.Read(messageID); err != nil {
+		return nil, err
+	}
+
+	var chunks [][]byte
+	for chunkIndex := 0; chunkIndex < chunkCount; chunkIndex++ {
+		payloadEnd := (chunkIndex + 1) * payloadSize
+		if payloadEnd > len(compressed) {
+			payloadEnd = len(compressed)
+		}
+
+		chunk := make([]byte, 0, gelfChunkHeaderSize+payloadSize)
+		chunk = append(chunk, 0x1e, 0x0f)
+		chunk = append(chunk, messageID...)
+		chunk = append(chunk, byte(chunkIndex), byte(chunkCount))
+		chunk = append(chunk, compressed[chunkIndex*payloadSize:payloadEnd]...)
+
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+func (w *GELFWriter) compress(message []byte) ([]byte, error) {
+	compressed := bytes.Buffer{}
+
+	switch w.config.Compression {
+	case GELFCompressionNone:
+		return message, nil
+
+	case GELFCompressionZlib:
+		zlibWriter := zlib.NewWriter(&compressed)
+		if _, err := zlibWriter.Write(message); err != nil {
+			return nil, err
+		}
+		if err := zlibWriter.Close(); err != nil {
+			return nil, err
+		}
+
+	default:
+		gzipWriter := gzip.NewWriter(&compressed)
+		if _, err := gzipWriter.Write(message); err != nil {
+			return nil, err
+		}
+		if err := gzipWriter.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	return compressed.Bytes(), nil
+}
+
+func (w *GELFWriter) writePackets(packets [][]byte) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.config.Network, w.config.Address, w.config.DialTimeout)
+		if err != nil {
+			return err
+		}
+
+		w.conn = conn
+	}
+
+	if w.config.WriteTimeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.config.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+
+	for _, packet := range packets {
+		if _, err := w.conn.Write(packet); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (w *GELFWriter) closeConn() error {
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
+
+// NewGELFLoggerus creates a logger which sends entries to a GELF input (e.g. Graylog)
+func NewGELFLoggerus(name string,
+	level logrus.Level,
+	formatterConfig GELFFormatterConfig,
+	writerConfig GELFWriterConfig) (*Loggerus, error) {
+
+	gelfFormatter, err := NewGELFFormatter(formatterConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	gelfWriter, err := NewGELFWriter(writerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLoggerus(name, level, gelfWriter, gelfFormatter)
+}
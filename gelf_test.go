@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type gelfSuite struct {
+	suite.Suite
+}
+
+func (suite *gelfSuite) TestFormat() {
+	formatter, err := NewGELFFormatter(GELFFormatterConfig{Host: "host"})
+	suite.Require().NoError(err)
+
+	formatted, err := formatter.Format(&logrus.Entry{
+		Time:    time.Unix(1614852000, 123000000),
+		Level:   logrus.ErrorLevel,
+		Message: "Failed",
+		Data: logrus.Fields{
+			"who":        "controller",
+			"id":         "abc",
+			"count":      3,
+			"stack":      "line1\nline2",
+			"bad key!":   true,
+			"attributes": map[string]string{"a": "b"},
+			"err":        fmt.Errorf("failed to connect, %w", errors.New("refused")),
+			"duration":   1500 * time.Millisecond,
+		},
+	})
+	suite.Require().NoError(err)
+
+	message := map[string]interface{}{}
+	suite.Require().NoError(json.Unmarshal(formatted, &message))
+
+	suite.Require().Equal(map[string]interface{}{
+		"version":       "1.1",
+		"host":          "host",
+		"short_message": "Failed",
+		"full_message":  "Failed\n* stack:\nline1\nline2\n",
+		"timestamp":     1614852000.123,
+		"level":         float64(3),
+		"_who":          "controller",
+		"_id_":          "abc",
+		"_count":        float64(3),
+		"_stack":        "line1\nline2",
+		"_bad_key_":     "true",
+		"_attributes":   `{"a":"b"}`,
+		"_err":          "failed to connect, refused",
+		"_duration":     "1.5s",
+	}, message)
+}
+
+func (suite *gelfSuite) TestUDPChunking() {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	defer listener.Close() // nolint: errcheck
+
+	loggerInstance, err := NewGELFLoggerus("test",
+		logrus.InfoLevel,
+		GELFFormatterConfig{},
+		GELFWriterConfig{
+			Network:   "udp",
+			Address:   listener.LocalAddr().String(),
+			ChunkSize: 100,
+		})
+	suite.Require().NoError(err)
+
+	// random enough not to compress below a single chunk
+	largeValue := ""
+	for index := 0; index < 100; index++ {
+		largeValue += time.Duration(index*7919).String() + ","
+	}
+
+	loggerInstance.InfoWith("Large", "value", largeValue)
+
+	// reassemble
+	chunks := map[byte][]byte{}
+	chunkCount := 0
+	var messageID []byte
+	for chunkCount == 0 || len(chunks) < chunkCount {
+		suite.Require().NoError(listener.SetReadDeadline(time.Now().Add(5 * time.Second)))
+		buffer := make([]byte, 200)
+		n, _, err := listener.ReadFrom(buffer)
+		suite.Require().NoError(err)
+		suite.Require().True(n <= 100)
+
+		chunk := buffer[:n]
+		suite.Require().Equal([]byte{0x1e, 0x0f}, chunk[:2])
+		if messageID == nil {
+			messageID = chunk[2:10]
+		}
+		suite.Require().Equal(messageID, chunk[2:10])
+
+		chunks[chunk[10]] = chunk[12:]
+		chunkCount = int(chunk[11])
+	}
+
+	suite.Require().True(chunkCount > 1)
+
+	compressed := bytes.Buffer{}
+	for chunkIndex := 0; chunkIndex < chunkCount; chunkIndex++ {
+		compressed.Write(chunks[byte(chunkIndex)]) // nolint: errcheck
+	}
+
+	gzipReader, err := gzip.NewReader(&compressed)
+	suite.Require().NoError(err)
+
+	decompressed, err := ioutil.ReadAll(gzipReader)
+	suite.Require().NoError(err)
+
+	message := map[string]interface{}{}
+	suite.Require().NoError(json.Unmarshal(decompressed, &message))
+	suite.Require().Equal(largeValue, message["_value"])
+}
+
+func (suite *gelfSuite) TestTCP() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	defer listener.Close() // nolint: errcheck
+
+	messagesChan := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close() // nolint: errcheck
+
+		reader := bufio.NewReader(conn)
+		for {
+			message, err := reader.ReadString(0)
+			if err != nil {
+				return
+			}
+
+			messagesChan <- strings.TrimSuffix(message, "\x00")
+		}
+	}()
+
+	loggerInstance, err := NewGELFLoggerus("test",
+		logrus.InfoLevel,
+		GELFFormatterConfig{},
+		GELFWriterConfig{Network: "tcp", Address: listener.Addr().String()})
+	suite.Require().NoError(err)
+
+	loggerInstance.WarnWith("First")
+	loggerInstance.WarnWith("Second")
+
+	for _, expectedMessage := range []string{"First", "Second"} {
+		select {
+		case received := <-messagesChan:
+			message := map[string]interface{}{}
+			suite.Require().NoError(json.Unmarshal([]byte(received), &message))
+			suite.Require().Equal(expectedMessage, message["short_message"])
+			suite.Require().Equal(float64(4), message["level"])
+		case <-time.After(5 * time.Second):
+			suite.Require().Fail("Timed out waiting for GELF message")
+		}
+	}
+}
+
+func TestGELFTestSuite(t *testing.T) {
+	suite.Run(t, new(gelfSuite))
+}
@@ -3,8 +3,11 @@ module github.com/nuclio/loggerus
 go 1.14
 
 require (
+	github.com/golang/snappy v0.0.4
 	github.com/logrusorgru/aurora/v3 v3.0.0
 	github.com/nuclio/logger v0.0.1
 	github.com/sirupsen/logrus v1.8.0
 	github.com/stretchr/testify v1.7.0
+	golang.org/x/sys v0.0.0-20191026070338-33540a1f6037
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,192 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type HTTPWriterConfig struct {
+
+	// endpoint to which batches are sent
+	URL string
+
+	// HTTP method (defaults to POST)
+	Method string
+
+	// additional headers sent with each request
+	Headers map[string]string
+
+	// basic authentication credentials, if set
+	Username string
+	Password string
+
+	// bearer token authentication, if set
+	BearerToken string
+
+	// timeout of a single request (defaults to 10 seconds, ignored if Client is set)
+	Timeout time.Duration
+
+	// client with which requests are sent
+	Client *http.Client
+
+	Batch BatchConfig
+}
+
+// an io.Writer sending batches of written entries as NDJSON to an HTTP endpoint
+type HTTPWriter struct {
+	*batchWriter
+	config HTTPWriterConfig
+	client *http.Client
+}
+
+func NewHTTPWriter(config HTTPWriterConfig) (*HTTPWriter, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("HTTP writer requires a URL")
+	}
+
+	if config.Method == "" {
+		config.Method = http.MethodPost
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+
+	newHTTPWriter := HTTPWriter{
+		config: config,
+		client: client,
+	}
+
+	batchWriterInstance, err := newBatchWriter(config.Batch, newHTTPWriter.sendEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	newHTTPWriter.batchWriter = batchWriterInstance
+
+	return &newHTTPWriter, nil
+}
+
+func (w *HTTPWriter) sendEntries(entries [][]byte) error {
+	body := bytes.Buffer{}
+	for _, entry := range entries {
+		body.Write(entry) // nolint: errcheck
+
+		// each entry must be on its own line
+		if len(entry) == 0 || entry[len(entry)-1] != '\n' {
+			body.WriteByte('\n') // nolint: errcheck
+		}
+	}
+
+	return sendHTTPRequest(w.client, w.config.Method, w.config.URL, &body, func(request *http.Request) {
+		request.Header.Set("Content-Type", "application/x-ndjson")
+		setHTTPRequestAuthentication(request, w.config.Username, w.config.Password, w.config.BearerToken)
+
+		for headerName, headerValue := range w.config.Headers {
+			request.Header.Set(headerName, headerValue)
+		}
+	})
+}
+
+// sends a request, classifying failures as retryable or permanent
+func sendHTTPRequest(client *http.Client,
+	method string,
+	url string,
+	body io.Reader,
+	prepareRequest func(*http.Request)) error {
+
+	_, err := sendHTTPRequestWithResponse(client, method, url, body, prepareRequest)
+	return err
+}
+
+// like sendHTTPRequest, returning the response body on success
+func sendHTTPRequestWithResponse(client *http.Client,
+	method string,
+	url string,
+	body io.Reader,
+	prepareRequest func(*http.Request)) ([]byte, error) {
+
+	request, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, &permanentError{err: fmt.Errorf("failed to create request, %w", err)}
+	}
+
+	prepareRequest(request)
+
+	// connection errors and timeouts are retryable
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request, %w", err)
+	}
+	defer response.Body.Close() // nolint: errcheck
+
+	responseBody, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response, %w", err)
+	}
+
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return responseBody, nil
+
+	case response.StatusCode >= 500 || response.StatusCode == http.StatusTooManyRequests:
+		return nil, fmt.Errorf("request failed with status %d, %s", response.StatusCode, string(responseBody))
+
+	default:
+		return nil, &permanentError{
+			err: fmt.Errorf("request rejected with status %d, %s", response.StatusCode, string(responseBody)),
+		}
+	}
+}
+
+func setHTTPRequestAuthentication(request *http.Request, username string, password string, bearerToken string) {
+	if username != "" || password != "" {
+		request.SetBasicAuth(username, password)
+	}
+
+	if bearerToken != "" {
+		request.Header.Set("Authorization", "Bearer "+bearerToken)
+	}
+}
+
+// NewHTTPLoggerus creates a logger which sends JSON formatted entries to an HTTP endpoint
+func NewHTTPLoggerus(name string, level logrus.Level, config HTTPWriterConfig) (*Loggerus, error) {
+	httpWriter, err := NewHTTPWriter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	loggerJSONFormatter, err := newJSONFormatter("", "")
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLoggerus(name, level, httpWriter, loggerJSONFormatter)
+}
@@ -0,0 +1,384 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type httpWriterSuite struct {
+	suite.Suite
+	server         *httptest.Server
+	lock           sync.Mutex
+	requests       []*http.Request
+	bodies         []string
+	failuresToGive int
+	failureStatus  int
+	droppedErrors  []error
+}
+
+func (suite *httpWriterSuite) SetupTest() {
+	suite.requests = nil
+	suite.bodies = nil
+	suite.droppedErrors = nil
+	suite.failuresToGive = 0
+	suite.failureStatus = http.StatusInternalServerError
+
+	suite.server = httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+
+		suite.lock.Lock()
+		defer suite.lock.Unlock()
+
+		if suite.failuresToGive > 0 {
+			suite.failuresToGive--
+			responseWriter.WriteHeader(suite.failureStatus)
+			return
+		}
+
+		suite.requests = append(suite.requests, request)
+		suite.bodies = append(suite.bodies, string(body))
+	}))
+}
+
+func (suite *httpWriterSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *httpWriterSuite) TestBatchByCount() {
+	loggerInstance, err := NewHTTPLoggerus("test", logrus.InfoLevel, HTTPWriterConfig{
+		URL:         suite.server.URL,
+		Headers:     map[string]string{"X-Tenant": "nuclio"},
+		BearerToken: "token",
+		Batch: BatchConfig{
+			MaxEntries:    2,
+			FlushInterval: time.Hour,
+		},
+	})
+	suite.Require().NoError(err)
+
+	// a logger.Logger, so it can be muxed with others
+	muxLogger, err := NewMuxLogger(loggerInstance)
+	suite.Require().NoError(err)
+
+	muxLogger.InfoWith("First", "key", "value")
+	muxLogger.InfoWith("Second")
+	muxLogger.InfoWith("Third")
+
+	// the first two were sent as a batch
+	suite.Require().Eventually(func() bool {
+		return len(suite.getBodies()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	lines := strings.Split(strings.TrimSuffix(suite.getBodies()[0], "\n"), "\n")
+	suite.Require().Len(lines, 2)
+
+	firstEntry := map[string]interface{}{}
+	suite.Require().NoError(json.Unmarshal([]byte(lines[0]), &firstEntry))
+	suite.Require().Equal("First", firstEntry["what"])
+
+	suite.lock.Lock()
+	request := suite.requests[0]
+	suite.lock.Unlock()
+	suite.Require().Equal("application/x-ndjson", request.Header.Get("Content-Type"))
+	suite.Require().Equal("Bearer token", request.Header.Get("Authorization"))
+	suite.Require().Equal("nuclio", request.Header.Get("X-Tenant"))
+
+	// the third is sent on flush
+	suite.Require().NoError(loggerInstance.GetOutput().(*HTTPWriter).Flush())
+	suite.Require().Len(suite.getBodies(), 2)
+	suite.Require().Contains(suite.getBodies()[1], `"what":"Third"`)
+}
+
+func (suite *httpWriterSuite) TestBatchByInterval() {
+	loggerInstance, err := NewHTTPLoggerus("test", logrus.InfoLevel, HTTPWriterConfig{
+		URL:   suite.server.URL,
+		Batch: BatchConfig{FlushInterval: 50 * time.Millisecond},
+	})
+	suite.Require().NoError(err)
+
+	loggerInstance.InfoWith("Eventually")
+
+	suite.Require().Eventually(func() bool {
+		return len(suite.getBodies()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func (suite *httpWriterSuite) TestRetryOnServerError() {
+	suite.failuresToGive = 2
+
+	writer := suite.createWriter(suite.server.URL, "")
+	_, err := writer.Write([]byte(`{"what":"retried"}` + "\n"))
+	suite.Require().NoError(err)
+
+	// flushing waits for the retries (closing doesn't)
+	suite.Require().NoError(writer.Flush())
+	suite.Require().NoError(writer.Close())
+
+	suite.Require().Equal([]string{`{"what":"retried"}` + "\n"}, suite.getBodies())
+}
+
+func (suite *httpWriterSuite) TestDropOnClientError() {
+	suite.failuresToGive = 1
+	suite.failureStatus = http.StatusBadRequest
+
+	tempDir, err := ioutil.TempDir("", "loggerus-spool-")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(tempDir) // nolint: errcheck
+
+	writer := suite.createWriter(suite.server.URL, tempDir)
+	_, err = writer.Write([]byte(`{"what":"rejected"}`))
+	suite.Require().NoError(err)
+	suite.Require().NoError(writer.Close())
+
+	// rejected batches are neither retried nor spooled
+	suite.Require().Empty(suite.getBodies())
+	suite.Require().Empty(suite.listSpool(tempDir))
+
+	// but reported
+	suite.lock.Lock()
+	defer suite.lock.Unlock()
+	suite.Require().Len(suite.droppedErrors, 1)
+	suite.Require().Contains(suite.droppedErrors[0].Error(), "dropped 1 log entries")
+}
+
+func (suite *httpWriterSuite) TestCloseWhileUnreachable() {
+	unreachableServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableURL := unreachableServer.URL
+	unreachableServer.Close()
+
+	writer, err := NewHTTPWriter(HTTPWriterConfig{
+		URL: unreachableURL,
+		Batch: BatchConfig{
+			MaxEntries:    1,
+			FlushInterval: time.Hour,
+			Backoff:       BackoffConfig{InitialInterval: time.Second},
+		},
+	})
+	suite.Require().NoError(err)
+
+	for index := 0; index < 4; index++ {
+		_, err = writer.Write([]byte(`{"what":"unsendable"}`))
+		suite.Require().NoError(err)
+	}
+
+	// the queued batches aren't retried once closing
+	closeStartTime := time.Now()
+	suite.Require().NoError(writer.Close())
+	suite.Require().Less(int64(time.Since(closeStartTime)), int64(time.Second))
+}
+
+func (suite *httpWriterSuite) TestFlushRacingClose() {
+	for iteration := 0; iteration < 1000; iteration++ {
+		writer, err := newBatchWriter(BatchConfig{FlushInterval: time.Hour}, func([][]byte) error {
+			return nil
+		})
+		suite.Require().NoError(err)
+
+		startChan := make(chan struct{})
+		flushedChan := make(chan struct{})
+		go func() {
+			<-startChan
+			writer.Flush() // nolint: errcheck
+			close(flushedChan)
+		}()
+
+		close(startChan)
+		suite.Require().NoError(writer.Close())
+
+		// the flush must not wait for a goroutine which already stopped
+		select {
+		case <-flushedChan:
+		case <-time.After(5 * time.Second):
+			suite.Require().Fail("flush racing with close didn't return")
+		}
+	}
+}
+
+func (suite *httpWriterSuite) TestSpoolSurvivesRestart() {
+	tempDir, err := ioutil.TempDir("", "loggerus-spool-")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(tempDir) // nolint: errcheck
+
+	// an endpoint that isn't listening
+	unreachableServer := httptest.NewServer(http.NotFoundHandler())
+	unreachableURL := unreachableServer.URL
+	unreachableServer.Close()
+
+	writer := suite.createWriter(unreachableURL, tempDir)
+	_, err = writer.Write([]byte(`{"what":"spooled"}`))
+	suite.Require().NoError(err)
+	suite.Require().NoError(writer.Close())
+	suite.Require().Len(suite.listSpool(tempDir), 1)
+
+	// a new writer with the same spool sends it once the endpoint is reachable
+	writer = suite.createWriter(suite.server.URL, tempDir)
+	defer writer.Close() // nolint: errcheck
+
+	suite.Require().Eventually(func() bool {
+		return len(suite.getBodies()) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	suite.Require().Equal(`{"what":"spooled"}`+"\n", suite.getBodies()[0])
+	suite.Require().Empty(suite.listSpool(tempDir))
+}
+
+func (suite *httpWriterSuite) TestSpoolKeepsOrderOfPartiallySentBatch() {
+	tempDir, err := ioutil.TempDir("", "loggerus-spool-")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(tempDir) // nolint: errcheck
+
+	spool, err := newDiskSpool(tempDir, 1024*1024)
+	suite.Require().NoError(err)
+	suite.Require().NoError(spool.push([][]byte{[]byte("first"), []byte("second")}))
+	suite.Require().NoError(spool.push([][]byte{[]byte("third")}))
+
+	var sentEntries []string
+	failedOnce := false
+	writer, err := newBatchWriter(BatchConfig{FlushInterval: 10 * time.Millisecond, SpoolDir: tempDir},
+		func(entries [][]byte) error {
+			suite.lock.Lock()
+			defer suite.lock.Unlock()
+
+			// the first send of the oldest batch only partially succeeds
+			if !failedOnce {
+				failedOnce = true
+				sentEntries = append(sentEntries, string(entries[0]))
+				return &partialSendError{entries: entries[1:], err: errors.New("unavailable")}
+			}
+
+			for _, entry := range entries {
+				sentEntries = append(sentEntries, string(entry))
+			}
+
+			return nil
+		})
+	suite.Require().NoError(err)
+	defer writer.Close() // nolint: errcheck
+
+	suite.Require().Eventually(func() bool {
+		suite.lock.Lock()
+		defer suite.lock.Unlock()
+
+		return len(sentEntries) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// what failed is retried before the batches spooled after it
+	suite.lock.Lock()
+	defer suite.lock.Unlock()
+	suite.Require().Equal([]string{"first", "second", "third"}, sentEntries)
+}
+
+func (suite *httpWriterSuite) TestRejectedSpooledBatchIsReported() {
+	tempDir, err := ioutil.TempDir("", "loggerus-spool-")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(tempDir) // nolint: errcheck
+
+	spool, err := newDiskSpool(tempDir, 1024*1024)
+	suite.Require().NoError(err)
+	suite.Require().NoError(spool.push([][]byte{[]byte("rejected")}))
+
+	suite.failuresToGive = 1
+	suite.failureStatus = http.StatusBadRequest
+
+	writer := suite.createWriter(suite.server.URL, tempDir)
+	defer writer.Close() // nolint: errcheck
+
+	suite.Require().Eventually(func() bool {
+		suite.lock.Lock()
+		defer suite.lock.Unlock()
+
+		return len(suite.droppedErrors) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	suite.Require().Empty(suite.listSpool(tempDir))
+	suite.Require().Empty(suite.getBodies())
+}
+
+func (suite *httpWriterSuite) TestSpoolIsBounded() {
+	tempDir, err := ioutil.TempDir("", "loggerus-spool-")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(tempDir) // nolint: errcheck
+
+	spool, err := newDiskSpool(tempDir, 100)
+	suite.Require().NoError(err)
+
+	for index := 0; index < 5; index++ {
+		suite.Require().NoError(spool.push([][]byte{[]byte(strings.Repeat("x", 40))}))
+	}
+
+	// only the two newest fit
+	suite.Require().Len(suite.listSpool(tempDir), 2)
+}
+
+func (suite *httpWriterSuite) createWriter(url string, spoolDir string) *HTTPWriter {
+	writer, err := NewHTTPWriter(HTTPWriterConfig{
+		URL: url,
+		Batch: BatchConfig{
+			FlushInterval: 10 * time.Millisecond,
+			SpoolDir:      spoolDir,
+			ErrorHandler: func(err error) {
+				suite.lock.Lock()
+				defer suite.lock.Unlock()
+
+				suite.droppedErrors = append(suite.droppedErrors, err)
+			},
+			Backoff: BackoffConfig{
+				InitialInterval: time.Millisecond,
+				MaxRetries:      3,
+			},
+		},
+	})
+	suite.Require().NoError(err)
+
+	return writer
+}
+
+func (suite *httpWriterSuite) getBodies() []string {
+	suite.lock.Lock()
+	defer suite.lock.Unlock()
+
+	return append([]string{}, suite.bodies...)
+}
+
+func (suite *httpWriterSuite) listSpool(dir string) []string {
+	fileInfos, err := ioutil.ReadDir(dir)
+	suite.Require().NoError(err)
+
+	var names []string
+	for _, fileInfo := range fileInfos {
+		names = append(names, fileInfo.Name())
+	}
+
+	return names
+}
+
+func TestHTTPWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(httpWriterSuite))
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	journaldDefaultSocketPath = "/run/systemd/journal/socket"
+	journaldMaxFieldNameLen   = 64
+)
+
+type JournaldFormatterConfig struct {
+
+	// SYSLOG_IDENTIFIER of entries without a logger name (defaults to the executable's name)
+	SyslogIdentifier string
+
+	// prepended to the journal field names of the structured fields (e.g. "NUCLIO_")
+	FieldPrefix string
+}
+
+// formats entries as journald native protocol datagrams - MESSAGE, PRIORITY, SYSLOG_IDENTIFIER and
+// each structured field as an uppercase journal field
+type JournaldFormatter struct {
+	config JournaldFormatterConfig
+}
+
+func NewJournaldFormatter(config JournaldFormatterConfig) (*JournaldFormatter, error) {
+	if config.SyslogIdentifier == "" {
+		config.SyslogIdentifier = filepath.Base(os.Args[0])
+	}
+
+	return &JournaldFormatter{
+		config: config,
+	}, nil
+}
+
+func (f *JournaldFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	buffer := bytes.Buffer{}
+
+	syslogIdentifier := getSyslogWho(entry)
+	if syslogIdentifier == "" {
+		syslogIdentifier = f.config.SyslogIdentifier
+	}
+
+	appendJournaldField(&buffer, "MESSAGE", entry.Message)
+	appendJournaldField(&buffer, "PRIORITY", strconv.Itoa(getSyslogSeverity(entry.Level)))
+	appendJournaldField(&buffer, "SYSLOG_IDENTIFIER", syslogIdentifier)
+
+	for _, fieldKey := range getSyslogFieldKeys(entry.Data) {
+		appendJournaldField(&buffer,
+			f.getFieldName(fieldKey),
+			getFieldValueString(entry.Data[fieldKey]))
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// journal field names are up to 64 of [A-Z0-9_], starting with a letter. fields which would
+// shadow the ones set by the formatter are prefixed
+func (f *JournaldFormatter) getFieldName(fieldKey string) string {
+	fieldName := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		default:
+			return '_'
+		}
+	}, f.config.FieldPrefix+fieldKey)
+
+	switch {
+	case fieldName == "", fieldName[0] < 'A' || fieldName[0] > 'Z':
+		fieldName = "FIELD_" + strings.TrimLeft(fieldName, "_")
+	case fieldName == "MESSAGE", fieldName == "PRIORITY", fieldName == "SYSLOG_IDENTIFIER":
+		fieldName = "FIELD_" + fieldName
+	}
+
+	if len(fieldName) > journaldMaxFieldNameLen {
+		fieldName = fieldName[:journaldMaxFieldNameLen]
+	}
+
+	return fieldName
+}
+
+// NAME=value, or for values with newlines NAME, followed by the little endian 64 bit length and the value
+func appendJournaldField(buffer *bytes.Buffer, name string, value string) {
+	buffer.WriteString(name) // nolint: errcheck
+
+	if strings.Contains(value, "\n") {
+		valueLength := make([]byte, 8)
+		binary.LittleEndian.PutUint64(valueLength, uint64(len(value)))
+
+		buffer.WriteByte('\n')    // nolint: errcheck
+		buffer.Write(valueLength) // nolint: errcheck
+		buffer.WriteString(value) // nolint: errcheck
+		buffer.WriteByte('\n')    // nolint: errcheck
+		return
+	}
+
+	buffer.WriteByte('=')     // nolint: errcheck
+	buffer.WriteString(value) // nolint: errcheck
+	buffer.WriteByte('\n')    // nolint: errcheck
+}
+
+type JournaldWriterConfig struct {
+
+	// path of journald's native protocol socket (defaults to /run/systemd/journal/socket)
+	SocketPath string
+}
+
+// NewJournaldLoggerus creates a logger which sends entries to systemd-journald
+func NewJournaldLoggerus(name string,
+	level logrus.Level,
+	formatterConfig JournaldFormatterConfig,
+	writerConfig JournaldWriterConfig) (*Loggerus, error) {
+
+	journaldFormatter, err := NewJournaldFormatter(formatterConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	journaldWriter, err := NewJournaldWriter(writerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLoggerus(name, level, journaldWriter, journaldFormatter)
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// an io.Writer sending each JournaldFormatter formatted write as a datagram to journald. entries too
+// large for a datagram are written to a sealed memfd whose descriptor is sent instead
+type JournaldWriter struct {
+	config JournaldWriterConfig
+	lock   sync.Mutex
+	conn   *net.UnixConn
+	closed bool
+}
+
+func NewJournaldWriter(config JournaldWriterConfig) (*JournaldWriter, error) {
+	if config.SocketPath == "" {
+		config.SocketPath = journaldDefaultSocketPath
+	}
+
+	// connecting lazily lets the logger be created before journald is up
+	return &JournaldWriter{
+		config: config,
+	}, nil
+}
+
+func (w *JournaldWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	// if journald restarted since the last write, reconnect and retry once
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = w.writeEntry(p); err == nil {
+			return len(p), nil
+		}
+
+		w.closeConn()
+	}
+
+	return 0, fmt.Errorf("failed to write to journald at %s, %w", w.config.SocketPath, err)
+}
+
+// Close closes the connection to journald
+func (w *JournaldWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.closed = true
+
+	return w.closeConn()
+}
+
+func (w *JournaldWriter) writeEntry(entry []byte) error {
+	if w.conn == nil {
+		conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: w.config.SocketPath, Net: "unixgram"})
+		if err != nil {
+			return err
+		}
+
+		w.conn = conn
+	}
+
+	_, err := w.conn.Write(entry)
+	if errors.Is(err, unix.EMSGSIZE) || errors.Is(err, unix.ENOBUFS) {
+		return w.writeEntryMemfd(entry)
+	}
+
+	return err
+}
+
+// the way sd_journal_send() passes large entries - an empty datagram carrying a sealed memfd
+func (w *JournaldWriter) writeEntryMemfd(entry []byte) error {
+	fd, err := unix.MemfdCreate("loggerus-journald", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		return fmt.Errorf("failed to create memfd, %w", err)
+	}
+
+	memfdFile := os.NewFile(uintptr(fd), "loggerus-journald")
+	defer memfdFile.Close() // nolint: errcheck
+
+	if _, err := memfdFile.Write(entry); err != nil {
+		return fmt.Errorf("failed to write entry to memfd, %w", err)
+	}
+
+	// journald only accepts memfds which can no longer be modified
+	if _, err := unix.FcntlInt(uintptr(fd),
+		unix.F_ADD_SEALS,
+		unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_WRITE|unix.F_SEAL_SEAL); err != nil {
+		return fmt.Errorf("failed to seal memfd, %w", err)
+	}
+
+	// WriteMsgUnix refuses connected datagram sockets, so sendmsg directly
+	rawConn, err := w.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sendErr error
+	if err := rawConn.Write(func(connFD uintptr) bool {
+		sendErr = unix.Sendmsg(int(connFD), nil, unix.UnixRights(fd), nil, 0)
+		return sendErr != unix.EAGAIN
+	}); err != nil {
+		return err
+	}
+
+	if sendErr != nil {
+		return fmt.Errorf("failed to send memfd, %w", sendErr)
+	}
+
+	return nil
+}
+
+func (w *JournaldWriter) closeConn() error {
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+	"golang.org/x/sys/unix"
+)
+
+type journaldWriterSuite struct {
+	suite.Suite
+	tempDir  string
+	listener *net.UnixConn
+}
+
+func (suite *journaldWriterSuite) SetupTest() {
+	var err error
+
+	suite.tempDir, err = ioutil.TempDir("", "journald-test")
+	suite.Require().NoError(err)
+
+	suite.listener, err = net.ListenUnixgram("unixgram", &net.UnixAddr{
+		Name: path.Join(suite.tempDir, "socket"),
+		Net:  "unixgram",
+	})
+	suite.Require().NoError(err)
+}
+
+func (suite *journaldWriterSuite) TearDownTest() {
+	suite.listener.Close()      // nolint: errcheck
+	os.RemoveAll(suite.tempDir) // nolint: errcheck
+}
+
+func (suite *journaldWriterSuite) TestDatagram() {
+	loggerInstance := suite.createLogger()
+
+	loggerInstance.ErrorWith("Failed", "stack", "line1\nline2")
+
+	payload, fds := suite.receive()
+	suite.Require().Empty(fds)
+	suite.Require().Equal([][2]string{
+		{"MESSAGE", "Failed"},
+		{"PRIORITY", "3"},
+		{"SYSLOG_IDENTIFIER", "test"},
+		{"STACK", "line1\nline2"},
+	}, parseJournaldPayload(suite.Require(), payload))
+}
+
+func (suite *journaldWriterSuite) TestMemfdFallback() {
+	loggerInstance := suite.createLogger()
+
+	// larger than the socket send buffer, so it can't be sent as a datagram
+	largeValue := strings.Repeat("x", 4*1024*1024)
+	loggerInstance.InfoWith("Large", "value", largeValue)
+
+	payload, fds := suite.receive()
+	suite.Require().Empty(payload)
+	suite.Require().Len(fds, 1)
+
+	memfdFile := os.NewFile(uintptr(fds[0]), "memfd")
+	defer memfdFile.Close() // nolint: errcheck
+
+	seals, err := unix.FcntlInt(memfdFile.Fd(), unix.F_GET_SEALS, 0)
+	suite.Require().NoError(err)
+	suite.Require().Equal(unix.F_SEAL_SHRINK|unix.F_SEAL_GROW|unix.F_SEAL_WRITE|unix.F_SEAL_SEAL, seals)
+
+	_, err = memfdFile.Seek(0, 0)
+	suite.Require().NoError(err)
+
+	contents, err := ioutil.ReadAll(memfdFile)
+	suite.Require().NoError(err)
+
+	suite.Require().Equal([][2]string{
+		{"MESSAGE", "Large"},
+		{"PRIORITY", "6"},
+		{"SYSLOG_IDENTIFIER", "test"},
+		{"VALUE", largeValue},
+	}, parseJournaldPayload(suite.Require(), contents))
+}
+
+func (suite *journaldWriterSuite) TestReconnect() {
+	loggerInstance := suite.createLogger()
+
+	loggerInstance.InfoWith("First")
+	suite.receive()
+
+	// journald restarts
+	suite.TearDownTest()
+	suite.SetupTest()
+	loggerInstance.GetOutput().(*JournaldWriter).config.SocketPath = suite.listener.LocalAddr().String()
+
+	loggerInstance.InfoWith("Second")
+
+	payload, _ := suite.receive()
+	suite.Require().Contains(string(payload), "MESSAGE=Second\n")
+}
+
+func (suite *journaldWriterSuite) createLogger() *Loggerus {
+	loggerInstance, err := NewJournaldLoggerus("test",
+		logrus.DebugLevel,
+		JournaldFormatterConfig{},
+		JournaldWriterConfig{SocketPath: suite.listener.LocalAddr().String()})
+	suite.Require().NoError(err)
+
+	return loggerInstance
+}
+
+func (suite *journaldWriterSuite) receive() ([]byte, []int) {
+	suite.Require().NoError(suite.listener.SetReadDeadline(time.Now().Add(5 * time.Second)))
+
+	buffer := make([]byte, 64*1024)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := suite.listener.ReadMsgUnix(buffer, oob)
+	suite.Require().NoError(err)
+
+	var fds []int
+	controlMessages, err := unix.ParseSocketControlMessage(oob[:oobn])
+	suite.Require().NoError(err)
+
+	for controlMessageIndex := range controlMessages {
+		messageFDs, err := unix.ParseUnixRights(&controlMessages[controlMessageIndex])
+		suite.Require().NoError(err)
+
+		fds = append(fds, messageFDs...)
+	}
+
+	return buffer[:n], fds
+}
+
+func TestJournaldWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(journaldWriterSuite))
+}
@@ -0,0 +1,40 @@
+//go:build !linux
+// +build !linux
+
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"fmt"
+)
+
+// journald exists only on linux
+type JournaldWriter struct{}
+
+func NewJournaldWriter(config JournaldWriterConfig) (*JournaldWriter, error) {
+	return nil, fmt.Errorf("journald is only supported on linux")
+}
+
+func (w *JournaldWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("journald is only supported on linux")
+}
+
+// Close does nothing
+func (w *JournaldWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type journaldSuite struct {
+	suite.Suite
+}
+
+func (suite *journaldSuite) TestFormat() {
+	formatter, err := NewJournaldFormatter(JournaldFormatterConfig{})
+	suite.Require().NoError(err)
+
+	formatted, err := formatter.Format(&logrus.Entry{
+		Time:    time.Now(),
+		Level:   logrus.WarnLevel,
+		Message: "Something happened",
+		Data: logrus.Fields{
+			"who":          "controller",
+			"ctx":          "abc",
+			"functionName": "echo",
+			"stack":        "line1\nline2",
+			"message":      "shadowed",
+			"1st":          1,
+		},
+	})
+	suite.Require().NoError(err)
+
+	suite.Require().Equal([][2]string{
+		{"MESSAGE", "Something happened"},
+		{"PRIORITY", "4"},
+		{"SYSLOG_IDENTIFIER", "controller"},
+		{"FIELD_1ST", "1"},
+		{"FUNCTIONNAME", "echo"},
+		{"FIELD_MESSAGE", "shadowed"},
+		{"STACK", "line1\nline2"},
+	}, parseJournaldPayload(suite.Require(), formatted))
+}
+
+func (suite *journaldSuite) TestFormatWithoutWho() {
+	formatter, err := NewJournaldFormatter(JournaldFormatterConfig{
+		SyslogIdentifier: "nuclio",
+		FieldPrefix:      "nuclio_",
+	})
+	suite.Require().NoError(err)
+
+	formatted, err := formatter.Format(&logrus.Entry{
+		Level:   logrus.DebugLevel,
+		Message: "Debugging",
+		Data:    logrus.Fields{"key": "value"},
+	})
+	suite.Require().NoError(err)
+
+	suite.Require().Equal([][2]string{
+		{"MESSAGE", "Debugging"},
+		{"PRIORITY", "7"},
+		{"SYSLOG_IDENTIFIER", "nuclio"},
+		{"NUCLIO_KEY", "value"},
+	}, parseJournaldPayload(suite.Require(), formatted))
+}
+
+// parses a native protocol payload into its fields, in order
+func parseJournaldPayload(requireInstance *require.Assertions, payload []byte) [][2]string {
+	var fields [][2]string
+
+	for len(payload) > 0 {
+		lineEnd := bytes.IndexByte(payload, '\n')
+		requireInstance.True(lineEnd >= 0)
+
+		line := payload[:lineEnd]
+		payload = payload[lineEnd+1:]
+
+		if separator := bytes.IndexByte(line, '='); separator >= 0 {
+			fields = append(fields, [2]string{string(line[:separator]), string(line[separator+1:])})
+			continue
+		}
+
+		requireInstance.True(len(payload) >= 8)
+		valueLength := int(binary.LittleEndian.Uint64(payload))
+		payload = payload[8:]
+
+		requireInstance.True(len(payload) > valueLength)
+		requireInstance.Equal(byte('\n'), payload[valueLength])
+
+		fields = append(fields, [2]string{string(line), string(payload[:valueLength])})
+		payload = payload[valueLength+1:]
+	}
+
+	return fields
+}
+
+func TestJournaldTestSuite(t *testing.T) {
+	suite.Run(t, new(journaldSuite))
+}
@@ -153,3 +153,28 @@ func convertValueToString(value interface{}) string {
 		return fmt.Sprintf("%v", value)
 	}
 }
+
+// Convert the given field value to string, marshaling slices, maps and structs to JSON
+func getFieldValueString(value interface{}) string {
+	switch typedValue := value.(type) {
+	case []byte:
+		return string(typedValue)
+	case nil:
+		return ""
+
+	// before marshaling, since errors are usually pointers to structs without exported fields (JSONFormatter
+	// also formats them with Error)
+	case error:
+		return typedValue.Error()
+	}
+
+	switch reflect.Indirect(reflect.ValueOf(value)).Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+		fieldValueBytes, err := json.Marshal(value)
+		if err == nil {
+			return string(fieldValueBytes)
+		}
+	}
+
+	return convertValueToString(value)
+}
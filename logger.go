@@ -164,6 +164,15 @@ func (l *Loggerus) DebugWithCtx(ctx context.Context, format interface{}, vars ..
 
 // Flush flushes buffered logs, if applicable
 func (l *Loggerus) Flush() {
+	if outputFlusher, ok := l.output.(flusher); ok {
+		outputFlusher.Flush() // nolint: errcheck
+	}
+}
+
+// Close flushes and closes the output, stopping its background goroutines if any. the output is shared
+// with the loggers returned by GetChild, and the standard streams are never closed
+func (l *Loggerus) Close() error {
+	return closeOutput(l.output)
 }
 
 // GetChild returns a child logger, if underlying logger supports hierarchal logging
@@ -252,3 +261,24 @@ func isVerboseTesting() bool {
 	}
 	return false
 }
+
+type flusher interface {
+	Flush() error
+}
+
+// closes an output if it can be closed (flushing it is the output's responsibility), otherwise flushes it
+func closeOutput(output io.Writer) error {
+	if outputFile, ok := output.(*os.File); ok && (outputFile == os.Stdout || outputFile == os.Stderr) {
+		return nil
+	}
+
+	if outputCloser, ok := output.(io.Closer); ok {
+		return outputCloser.Close()
+	}
+
+	if outputFlusher, ok := output.(flusher); ok {
+		return outputFlusher.Flush()
+	}
+
+	return nil
+}
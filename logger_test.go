@@ -18,9 +18,13 @@ package loggerus
 
 import (
 	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/nuclio/logger"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/suite"
 )
 
@@ -59,6 +63,35 @@ func (suite *loggerSuite) TestLogWithCtx() {
 	suite.logger.InfoWithCtx(ctx, "test", "with", "something")
 }
 
+func (suite *loggerSuite) TestClose() {
+	tempDir, err := ioutil.TempDir("", "loggerus-close-")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(tempDir) // nolint: errcheck
+
+	writer, err := NewRotatingFileWriter(RotatingFileWriterConfig{
+		Path: filepath.Join(tempDir, "test.log"),
+	})
+	suite.Require().NoError(err)
+
+	loggerInstance, err := NewJSONLoggerus("test", logrus.InfoLevel, writer)
+	suite.Require().NoError(err)
+
+	loggerInstance.InfoWith("Before close")
+	loggerInstance.Flush()
+	suite.Require().NoError(loggerInstance.Close())
+
+	_, err = writer.Write([]byte("after close\n"))
+	suite.Require().Error(err)
+
+	// the standard streams aren't closed
+	stdoutLogger, err := NewJSONLoggerus("test", logrus.InfoLevel, NewRedactor(os.Stdout))
+	suite.Require().NoError(err)
+	suite.Require().NoError(stdoutLogger.Close())
+
+	_, err = os.Stdout.Write(nil)
+	suite.Require().NoError(err)
+}
+
 func TestLoggerTestSuite(t *testing.T) {
 	suite.Run(t, new(loggerSuite))
 }
@@ -0,0 +1,400 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/sirupsen/logrus"
+)
+
+type LokiEncoding int
+
+const (
+
+	// snappy compressed protobuf, Loki's native push format
+	LokiEncodingProtobuf LokiEncoding = iota
+	LokiEncodingJSON
+)
+
+type LokiFormatterConfig struct {
+
+	// entry fields which become stream labels rather than part of the line. "who", "severity" and
+	// "function" (requires logrus caller reporting) are derived from the entry, any other name is
+	// taken from the structured fields. keep these low cardinality (defaults to who and severity)
+	LabelFields []string
+
+	// labels added to every stream (e.g. job, namespace)
+	StaticLabels map[string]string
+}
+
+// formats entries for LokiWriter - labels, plus the remaining fields rendered as a JSONFormatter line
+type LokiFormatter struct {
+	config        LokiFormatterConfig
+	jsonFormatter *JSONFormatter
+}
+
+// what LokiFormatter hands to LokiWriter
+type lokiEntry struct {
+	Timestamp int64             `json:"ts"`
+	Labels    map[string]string `json:"labels"`
+	Line      string            `json:"line"`
+}
+
+func NewLokiFormatter(config LokiFormatterConfig) (*LokiFormatter, error) {
+	if config.LabelFields == nil {
+		config.LabelFields = []string{"who", "severity"}
+	}
+
+	jsonFormatter, err := newJSONFormatter("", "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &LokiFormatter{
+		config:        config,
+		jsonFormatter: jsonFormatter,
+	}, nil
+}
+
+func (f *LokiFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	labels := map[string]string{}
+	for labelName, labelValue := range f.config.StaticLabels {
+		labels[getLokiLabelName(labelName)] = labelValue
+	}
+
+	lineData := make(logrus.Fields, len(entry.Data))
+	for fieldKey, fieldValue := range entry.Data {
+		lineData[fieldKey] = fieldValue
+	}
+
+	for _, labelField := range f.config.LabelFields {
+		switch labelField {
+		case "severity":
+			labels[labelField] = strings.ToUpper(entry.Level.String())
+
+		case "function":
+			if entry.HasCaller() {
+				labels[labelField] = entry.Caller.Function
+			}
+
+		default:
+			fieldValue, ok := entry.Data[labelField]
+			if !ok || fieldValue == nil {
+				continue
+			}
+
+			labels[getLokiLabelName(labelField)] = getFieldValueString(fieldValue)
+
+			// who is part of the JSONFormatter schema, other fields would be duplicated in "more"
+			if labelField != "who" {
+				delete(lineData, labelField)
+			}
+		}
+	}
+
+	line, err := f.jsonFormatter.Format(&logrus.Entry{
+		Logger:  entry.Logger,
+		Data:    lineData,
+		Time:    entry.Time,
+		Level:   entry.Level,
+		Caller:  entry.Caller,
+		Message: entry.Message,
+		Context: entry.Context,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(lokiEntry{
+		Timestamp: entry.Time.UnixNano(),
+		Labels:    labels,
+		Line:      string(bytes.TrimSuffix(line, []byte("\n"))),
+	})
+}
+
+// label names must match [a-zA-Z_][a-zA-Z0-9_]*
+func getLokiLabelName(name string) string {
+	labelName := []byte(name)
+	for charIndex, char := range labelName {
+		isLetter := (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || char == '_'
+		isDigit := char >= '0' && char <= '9'
+
+		if !isLetter && !(isDigit && charIndex > 0) {
+			labelName[charIndex] = '_'
+		}
+	}
+
+	return string(labelName)
+}
+
+type LokiWriterConfig struct {
+
+	// push endpoint (e.g. http://loki:3100/loki/api/v1/push)
+	URL string
+
+	Encoding LokiEncoding
+
+	// sent as X-Scope-OrgID, for multi tenant Loki
+	TenantID string
+
+	// basic authentication credentials, if set
+	Username string
+	Password string
+
+	// bearer token authentication, if set
+	BearerToken string
+
+	// additional headers sent with each request
+	Headers map[string]string
+
+	// timeout of a single request (defaults to 10 seconds, ignored if Client is set)
+	Timeout time.Duration
+
+	// client with which requests are sent
+	Client *http.Client
+
+	Batch BatchConfig
+}
+
+// an io.Writer pushing batches of LokiFormatter formatted entries to Loki
+type LokiWriter struct {
+	*batchWriter
+	config LokiWriterConfig
+	client *http.Client
+}
+
+func NewLokiWriter(config LokiWriterConfig) (*LokiWriter, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("Loki writer requires a URL")
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	client := config.Client
+	if client == nil {
+		client = &http.Client{Timeout: config.Timeout}
+	}
+
+	newLokiWriter := LokiWriter{
+		config: config,
+		client: client,
+	}
+
+	batchWriterInstance, err := newBatchWriter(config.Batch, newLokiWriter.sendEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	newLokiWriter.batchWriter = batchWriterInstance
+
+	return &newLokiWriter, nil
+}
+
+type lokiStream struct {
+	labels  map[string]string
+	entries []lokiEntry
+}
+
+func (w *LokiWriter) sendEntries(entries [][]byte) error {
+	streams := w.groupEntriesToStreams(entries)
+	if len(streams) == 0 {
+		return nil
+	}
+
+	var body []byte
+	var contentType string
+	var err error
+
+	switch w.config.Encoding {
+	case LokiEncodingJSON:
+		contentType = "application/json"
+		body, err = encodeLokiPushRequestJSON(streams)
+		if err != nil {
+			return &permanentError{err: err}
+		}
+
+	default:
+		contentType = "application/x-protobuf"
+		body = snappy.Encode(nil, encodeLokiPushRequestProtobuf(streams))
+	}
+
+	return sendHTTPRequest(w.client, http.MethodPost, w.config.URL, bytes.NewReader(body), func(request *http.Request) {
+		request.Header.Set("Content-Type", contentType)
+		setHTTPRequestAuthentication(request, w.config.Username, w.config.Password, w.config.BearerToken)
+
+		if w.config.TenantID != "" {
+			request.Header.Set("X-Scope-OrgID", w.config.TenantID)
+		}
+
+		for headerName, headerValue := range w.config.Headers {
+			request.Header.Set(headerName, headerValue)
+		}
+	})
+}
+
+// Loki expects one stream per label set, with entries ordered by time. entries which can't be decoded are
+// dropped rather than failing the batch
+func (w *LokiWriter) groupEntriesToStreams(entries [][]byte) []*lokiStream {
+	streamsByLabels := map[string]*lokiStream{}
+	var streams []*lokiStream
+
+	for _, entry := range entries {
+		var decodedEntry lokiEntry
+		if err := json.Unmarshal(entry, &decodedEntry); err != nil {
+			reportDroppedEntries(w.config.Batch.ErrorHandler,
+				1,
+				fmt.Errorf("failed to decode entry (was it formatted with LokiFormatter?), %w", err))
+			continue
+		}
+
+		labelsString := formatLokiLabels(decodedEntry.Labels)
+		stream, found := streamsByLabels[labelsString]
+		if !found {
+			stream = &lokiStream{labels: decodedEntry.Labels}
+			streamsByLabels[labelsString] = stream
+			streams = append(streams, stream)
+		}
+
+		stream.entries = append(stream.entries, decodedEntry)
+	}
+
+	for _, stream := range streams {
+		sort.SliceStable(stream.entries, func(i, j int) bool {
+			return stream.entries[i].Timestamp < stream.entries[j].Timestamp
+		})
+	}
+
+	return streams
+}
+
+// {name="value", name2="value2"}
+func formatLokiLabels(labels map[string]string) string {
+	var labelNames []string
+	for labelName := range labels {
+		labelNames = append(labelNames, labelName)
+	}
+
+	sort.Strings(labelNames)
+
+	var formattedLabels []string
+	for _, labelName := range labelNames {
+		formattedLabels = append(formattedLabels, labelName+"="+strconv.Quote(labels[labelName]))
+	}
+
+	return "{" + strings.Join(formattedLabels, ", ") + "}"
+}
+
+func encodeLokiPushRequestJSON(streams []*lokiStream) ([]byte, error) {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	pushRequest := struct {
+		Streams []jsonStream `json:"streams"`
+	}{}
+
+	for _, stream := range streams {
+		encodedStream := jsonStream{Stream: stream.labels}
+		for _, entry := range stream.entries {
+			encodedStream.Values = append(encodedStream.Values,
+				[2]string{strconv.FormatInt(entry.Timestamp, 10), entry.Line})
+		}
+
+		pushRequest.Streams = append(pushRequest.Streams, encodedStream)
+	}
+
+	return json.Marshal(pushRequest)
+}
+
+// encodes logproto.PushRequest:
+//
+//	PushRequest   { repeated StreamAdapter streams = 1; }
+//	StreamAdapter { string labels = 1; repeated EntryAdapter entries = 2; }
+//	EntryAdapter  { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+//	Timestamp     { int64 seconds = 1; int32 nanos = 2; }
+func encodeLokiPushRequestProtobuf(streams []*lokiStream) []byte {
+	var pushRequest []byte
+
+	for _, stream := range streams {
+		var encodedStream []byte
+		encodedStream = appendProtobufBytes(encodedStream, 1, []byte(formatLokiLabels(stream.labels)))
+
+		for _, entry := range stream.entries {
+			var timestamp []byte
+			timestamp = appendProtobufVarint(timestamp, 1, uint64(entry.Timestamp/int64(time.Second)))
+			timestamp = appendProtobufVarint(timestamp, 2, uint64(entry.Timestamp%int64(time.Second)))
+
+			var encodedEntry []byte
+			encodedEntry = appendProtobufBytes(encodedEntry, 1, timestamp)
+			encodedEntry = appendProtobufBytes(encodedEntry, 2, []byte(entry.Line))
+
+			encodedStream = appendProtobufBytes(encodedStream, 2, encodedEntry)
+		}
+
+		pushRequest = appendProtobufBytes(pushRequest, 1, encodedStream)
+	}
+
+	return pushRequest
+}
+
+func appendProtobufVarint(buffer []byte, fieldNumber int, value uint64) []byte {
+	buffer = appendUvarint(buffer, uint64(fieldNumber<<3))
+	return appendUvarint(buffer, value)
+}
+
+func appendProtobufBytes(buffer []byte, fieldNumber int, value []byte) []byte {
+	buffer = appendUvarint(buffer, uint64(fieldNumber<<3|2))
+	buffer = appendUvarint(buffer, uint64(len(value)))
+	return append(buffer, value...)
+}
+
+func appendUvarint(buffer []byte, value uint64) []byte {
+	varintBuffer := make([]byte, binary.MaxVarintLen64)
+	return append(buffer, varintBuffer[:binary.PutUvarint(varintBuffer, value)]...)
+}
+
+// NewLokiLoggerus creates a logger which pushes entries to Loki
+func NewLokiLoggerus(name string,
+	level logrus.Level,
+	formatterConfig LokiFormatterConfig,
+	writerConfig LokiWriterConfig) (*Loggerus, error) {
+
+	lokiFormatter, err := NewLokiFormatter(formatterConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	lokiWriter, err := NewLokiWriter(writerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLoggerus(name, level, lokiWriter, lokiFormatter)
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type lokiSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	lock     sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (suite *lokiSuite) SetupTest() {
+	suite.requests = nil
+	suite.bodies = nil
+
+	suite.server = httptest.NewServer(http.HandlerFunc(func(responseWriter http.ResponseWriter, request *http.Request) {
+		body, _ := ioutil.ReadAll(request.Body)
+
+		suite.lock.Lock()
+		defer suite.lock.Unlock()
+
+		suite.requests = append(suite.requests, request)
+		suite.bodies = append(suite.bodies, body)
+		responseWriter.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func (suite *lokiSuite) TearDownTest() {
+	suite.server.Close()
+}
+
+func (suite *lokiSuite) TestPushJSON() {
+	loggerInstance := suite.createLogger(LokiFormatterConfig{
+		LabelFields:  []string{"who", "severity", "component"},
+		StaticLabels: map[string]string{"job": "nuclio"},
+	}, LokiEncodingJSON)
+
+	loggerInstance.InfoWith("First", "component", "dlx", "key", "value")
+	loggerInstance.InfoWith("Second", "component", "dlx")
+	loggerInstance.WarnWith("Third", "component", "dlx")
+	suite.Require().NoError(loggerInstance.GetOutput().(*LokiWriter).Flush())
+
+	suite.Require().Len(suite.bodies, 1)
+	suite.Require().Equal("application/json", suite.requests[0].Header.Get("Content-Type"))
+	suite.Require().Equal("tenant", suite.requests[0].Header.Get("X-Scope-OrgID"))
+
+	pushRequest := struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}{}
+	suite.Require().NoError(json.Unmarshal(suite.bodies[0], &pushRequest))
+
+	// one stream per label set
+	suite.Require().Len(pushRequest.Streams, 2)
+	suite.Require().Equal(map[string]string{
+		"job":       "nuclio",
+		"who":       "test",
+		"severity":  "INFO",
+		"component": "dlx",
+	}, pushRequest.Streams[0].Stream)
+	suite.Require().Len(pushRequest.Streams[0].Values, 2)
+	suite.Require().Equal("WARNING", pushRequest.Streams[1].Stream["severity"])
+
+	// label fields are not repeated in the line
+	line := map[string]interface{}{}
+	suite.Require().NoError(json.Unmarshal([]byte(pushRequest.Streams[0].Values[0][1]), &line))
+	suite.Require().Equal("First", line["what"])
+	suite.Require().Equal(map[string]interface{}{"key": "value"}, line["more"])
+}
+
+func (suite *lokiSuite) TestPushProtobuf() {
+	loggerInstance := suite.createLogger(LokiFormatterConfig{}, LokiEncodingProtobuf)
+
+	loggerInstance.ErrorWith("Failed", "key", "value")
+	suite.Require().NoError(loggerInstance.GetOutput().(*LokiWriter).Flush())
+
+	suite.Require().Len(suite.bodies, 1)
+	suite.Require().Equal("application/x-protobuf", suite.requests[0].Header.Get("Content-Type"))
+
+	pushRequest, err := snappy.Decode(nil, suite.bodies[0])
+	suite.Require().NoError(err)
+
+	streams := suite.decodeProtobufFields(pushRequest)[1]
+	suite.Require().Len(streams, 1)
+
+	stream := suite.decodeProtobufFields(streams[0])
+	suite.Require().Equal(`{severity="ERROR", who="test"}`, string(stream[1][0]))
+
+	entry := suite.decodeProtobufFields(stream[2][0])
+	suite.Require().Contains(string(entry[2][0]), `"what":"Failed"`)
+
+	seconds, _ := binary.Uvarint(suite.decodeProtobufFields(entry[1][0])[1][0])
+	suite.Require().InDelta(time.Now().Unix(), int64(seconds), 60)
+}
+
+func (suite *lokiSuite) TestUndecodableEntryIsDropped() {
+	var droppedErrors []error
+	writer, err := NewLokiWriter(LokiWriterConfig{
+		URL:      suite.server.URL + "/loki/api/v1/push",
+		Encoding: LokiEncodingJSON,
+		Batch: BatchConfig{
+			FlushInterval: time.Hour,
+			ErrorHandler: func(err error) {
+				droppedErrors = append(droppedErrors, err)
+			},
+		},
+	})
+	suite.Require().NoError(err)
+
+	formatter, err := NewLokiFormatter(LokiFormatterConfig{})
+	suite.Require().NoError(err)
+
+	entry, err := formatter.Format(&logrus.Entry{Time: time.Now(), Level: logrus.InfoLevel, Message: "Decodable"})
+	suite.Require().NoError(err)
+
+	_, err = writer.Write([]byte("not formatted with LokiFormatter"))
+	suite.Require().NoError(err)
+	_, err = writer.Write(entry)
+	suite.Require().NoError(err)
+	suite.Require().NoError(writer.Close())
+
+	// the rest of the batch is still pushed
+	suite.Require().Len(suite.bodies, 1)
+	suite.Require().Contains(string(suite.bodies[0]), "Decodable")
+	suite.Require().Len(droppedErrors, 1)
+	suite.Require().Contains(droppedErrors[0].Error(), "failed to decode entry")
+}
+
+func (suite *lokiSuite) createLogger(formatterConfig LokiFormatterConfig, encoding LokiEncoding) *Loggerus {
+	loggerInstance, err := NewLokiLoggerus("test", logrus.DebugLevel, formatterConfig, LokiWriterConfig{
+		URL:      suite.server.URL + "/loki/api/v1/push",
+		Encoding: encoding,
+		TenantID: "tenant",
+		Batch:    BatchConfig{FlushInterval: time.Hour},
+	})
+	suite.Require().NoError(err)
+
+	return loggerInstance
+}
+
+// decodes a protobuf message into field number -> values. varints are returned re-encoded
+func (suite *lokiSuite) decodeProtobufFields(message []byte) map[int][][]byte {
+	fields := map[int][][]byte{}
+
+	for len(message) > 0 {
+		key, keySize := binary.Uvarint(message)
+		suite.Require().True(keySize > 0)
+		message = message[keySize:]
+
+		fieldNumber := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			_, valueSize := binary.Uvarint(message)
+			fields[fieldNumber] = append(fields[fieldNumber], message[:valueSize])
+			message = message[valueSize:]
+
+		case 2:
+			length, lengthSize := binary.Uvarint(message)
+			message = message[lengthSize:]
+			fields[fieldNumber] = append(fields[fieldNumber], message[:length])
+			message = message[length:]
+
+		default:
+			suite.Require().Fail("Unexpected wire type")
+		}
+	}
+
+	return fields
+}
+
+func TestLokiTestSuite(t *testing.T) {
+	suite.Run(t, new(lokiSuite))
+}
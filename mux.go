@@ -18,133 +18,734 @@ package loggerus
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"path"
+	"sync"
+	"time"
 
 	"github.com/nuclio/logger"
 )
 
-// a logger that multiplexes logs towards multiple loggers
+// conditions under which a MuxLogger forwards entries to one of its loggers. the zero value forwards everything
+type MuxLoggerOptions struct {
+
+	// by which routes of the routing table refer to the logger
+	Name string
+
+	// minimum level forwarded
+	Level logger.Level
+
+	// glob patterns (as in path.Match) of logger names to forward - if empty, all are forwarded. the names are
+	// those passed to GetChild, joined with "." (the name of the logger returned by NewMuxLogger is "")
+	IncludeNames []string
+
+	// glob patterns of logger names not to forward, taking precedence over IncludeNames
+	ExcludeNames []string
+
+	// all must hold for an entry to be forwarded
+	FieldPredicates []MuxFieldPredicate
+
+	// entries are forwarded from a goroutine through a queue of this size, so that a logger which blocks
+	// doesn't block logging (0 means forwarding synchronously)
+	QueueSize int
+
+	// how long to wait for room in a full queue before dropping an entry, and how long a call to the logger
+	// from the queue may take before it's considered unhealthy (0 means not waiting, and no limit). requires
+	// QueueSize - a synchronous call blocks the caller, so it can't be abandoned, however long it takes
+	Timeout time.Duration
+
+	// receives the entries while the logger is unhealthy - i.e. it panicked or dropped an entry within the
+	// last RetryInterval, or is stuck in a call from its queue for longer than Timeout
+	Fallback logger.Logger
+
+	// how long a logger is considered unhealthy after a failure (defaults to 10 seconds)
+	RetryInterval time.Duration
+
+	// called with the failures of the logger (once per streak of failures) and of its Fallback, e.g. to
+	// report them with another logger (if nil, they're written to stderr)
+	ErrorHandler func(error)
+}
+
+// receives the fields of structured (With) entries, or nil for entries without fields
+type MuxFieldPredicate func(fields map[string]interface{}) bool
+
+// identifies a logger added to a MuxLogger
+type MuxLoggerHandle uint64
+
+// never modified once created, so that entries can be forwarded without holding locks
+type muxChild struct {
+	handle   MuxLoggerHandle
+	logger   logger.Logger
+	fallback logger.Logger
+	options  MuxLoggerOptions
+	state    *muxChildState
+
+	// the generation of the root in which the options were set, so that children know whether to derive the
+	// fallback again (loggers aren't necessarily comparable)
+	optionsGeneration uint64
+}
+
+// a logger that multiplexes logs towards multiple loggers. loggers may be added and removed at any time,
+// including through children returned by GetChild, which share the loggers of the mux logger they were
+// created from
 type MuxLogger struct {
-	loggers []logger.Logger
+	name      string
+	childName string
+	parent    *MuxLogger
+	lock      sync.RWMutex
+
+	// copy on write. for children, these are the loggers derived from the parent's through GetChild
+	children []*muxChild
+
+	// incremented on every change to the loggers of the root, so that children know to derive them again
+	generation uint64
+	nextHandle MuxLoggerHandle
+
+	// of the root, if any - otherwise entries are forwarded to all loggers
+	routingTable *MuxRoutingTable
 }
 
 func NewMuxLogger(loggers ...logger.Logger) (*MuxLogger, error) {
-	return &MuxLogger{loggers: loggers}, nil
+	newMuxLogger := MuxLogger{}
+	newMuxLogger.SetLoggers(loggers...)
+
+	return &newMuxLogger, nil
 }
 
+// SetLoggers replaces the loggers, forwarding everything to each
 func (ml *MuxLogger) SetLoggers(loggers ...logger.Logger) {
-	ml.loggers = loggers
+	rootMuxLogger := ml.getRoot()
+
+	rootMuxLogger.lock.Lock()
+	defer rootMuxLogger.lock.Unlock()
+
+	for _, child := range rootMuxLogger.children {
+		child.state.stop()
+	}
+
+	children := make([]*muxChild, 0, len(loggers))
+	for _, loggerInstance := range loggers {
+		rootMuxLogger.nextHandle++
+		children = append(children, newMuxChild(rootMuxLogger.nextHandle, loggerInstance, MuxLoggerOptions{}))
+	}
+
+	rootMuxLogger.setChildren(children)
 }
 
 func (ml *MuxLogger) GetLoggers() []logger.Logger {
-	return ml.loggers
+	children, _ := ml.getChildren()
+
+	loggers := make([]logger.Logger, 0, len(children))
+	for _, child := range children {
+		loggers = append(loggers, child.logger)
+	}
+
+	return loggers
+}
+
+// AddLogger adds a logger to which entries are forwarded according to the options, returning a handle
+// with which it can be removed
+func (ml *MuxLogger) AddLogger(loggerInstance logger.Logger, options MuxLoggerOptions) (MuxLoggerHandle, error) {
+	if err := options.validate(); err != nil {
+		return 0, err
+	}
+
+	rootMuxLogger := ml.getRoot()
+
+	rootMuxLogger.lock.Lock()
+	defer rootMuxLogger.lock.Unlock()
+
+	rootMuxLogger.nextHandle++
+	children := append(append([]*muxChild{}, rootMuxLogger.children...),
+		newMuxChild(rootMuxLogger.nextHandle, loggerInstance, options))
+
+	rootMuxLogger.setChildren(children)
+
+	return rootMuxLogger.nextHandle, nil
+}
+
+// RemoveLogger stops forwarding entries to a logger added with AddLogger
+func (ml *MuxLogger) RemoveLogger(handle MuxLoggerHandle) error {
+	rootMuxLogger := ml.getRoot()
+
+	rootMuxLogger.lock.Lock()
+	defer rootMuxLogger.lock.Unlock()
+
+	var children []*muxChild
+	for _, child := range rootMuxLogger.children {
+		if child.handle != handle {
+			children = append(children, child)
+		} else {
+			child.state.stop()
+		}
+	}
+
+	if len(children) == len(rootMuxLogger.children) {
+		return fmt.Errorf("no logger with handle %d", handle)
+	}
+
+	rootMuxLogger.setChildren(children)
+
+	return nil
+}
+
+// SetLoggerOptions sets the conditions for forwarding entries to one of the loggers (or to a logger derived
+// from it, when called on a child)
+func (ml *MuxLogger) SetLoggerOptions(loggerInstance logger.Logger, options MuxLoggerOptions) error {
+	if err := options.validate(); err != nil {
+		return err
+	}
+
+	currentChildren, _ := ml.getChildren()
+	for _, child := range currentChildren {
+		if child.logger == loggerInstance {
+			return ml.getRoot().setLoggerOptions(child.handle, options)
+		}
+	}
+
+	return fmt.Errorf("logger is not multiplexed by this mux logger")
+}
+
+// SetRoutingTable sets the routing table of the mux logger and its children (nil forwards entries to all
+// loggers again). the loggers it routes to must already be added, by their names
+func (ml *MuxLogger) SetRoutingTable(routingTable *MuxRoutingTable) error {
+	if routingTable != nil {
+
+		// entries are routed without holding locks, so the caller must not be able to modify the table
+		routingTable = routingTable.clone()
+
+		if err := routingTable.validate(); err != nil {
+			return err
+		}
+	}
+
+	rootMuxLogger := ml.getRoot()
+
+	rootMuxLogger.lock.Lock()
+	defer rootMuxLogger.lock.Unlock()
+
+	if routingTable != nil {
+		loggerNames := map[string]bool{}
+		for _, child := range rootMuxLogger.children {
+			loggerNames[child.options.Name] = true
+		}
+
+		if err := routingTable.validateLoggerNames(loggerNames); err != nil {
+			return err
+		}
+	}
+
+	rootMuxLogger.routingTable = routingTable
+
+	return nil
+}
+
+// GetLoggerHealth returns the health of one of the loggers (or of the logger it was derived from, when
+// called on a child)
+func (ml *MuxLogger) GetLoggerHealth(loggerInstance logger.Logger) (MuxLoggerHealth, error) {
+	currentChildren, _ := ml.getChildren()
+	for _, child := range currentChildren {
+		if child.logger == loggerInstance {
+			return child.state.getHealth(&child.options), nil
+		}
+	}
+
+	return MuxLoggerHealth{}, fmt.Errorf("logger is not multiplexed by this mux logger")
 }
 
 func (ml *MuxLogger) Error(format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelError, nil, func(loggerInstance logger.Logger) {
 		loggerInstance.Error(format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) ErrorCtx(ctx context.Context, format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelError, nil, func(loggerInstance logger.Logger) {
 		loggerInstance.ErrorCtx(ctx, format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) Warn(format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelWarn, nil, func(loggerInstance logger.Logger) {
 		loggerInstance.Warn(format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) WarnCtx(ctx context.Context, format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelWarn, nil, func(loggerInstance logger.Logger) {
 		loggerInstance.WarnCtx(ctx, format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) Info(format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelInfo, nil, func(loggerInstance logger.Logger) {
 		loggerInstance.Info(format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) InfoCtx(ctx context.Context, format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelInfo, nil, func(loggerInstance logger.Logger) {
 		loggerInstance.InfoCtx(ctx, format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) Debug(format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelDebug, nil, func(loggerInstance logger.Logger) {
 		loggerInstance.Debug(format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) DebugCtx(ctx context.Context, format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelDebug, nil, func(loggerInstance logger.Logger) {
 		loggerInstance.DebugCtx(ctx, format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) ErrorWith(format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelError, vars, func(loggerInstance logger.Logger) {
 		loggerInstance.ErrorWith(format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) ErrorWithCtx(ctx context.Context, format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelError, vars, func(loggerInstance logger.Logger) {
 		loggerInstance.ErrorWithCtx(ctx, format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) WarnWith(format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelWarn, vars, func(loggerInstance logger.Logger) {
 		loggerInstance.WarnWith(format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) WarnWithCtx(ctx context.Context, format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelWarn, vars, func(loggerInstance logger.Logger) {
 		loggerInstance.WarnWithCtx(ctx, format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) InfoWith(format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelInfo, vars, func(loggerInstance logger.Logger) {
 		loggerInstance.InfoWith(format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) InfoWithCtx(ctx context.Context, format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelInfo, vars, func(loggerInstance logger.Logger) {
 		loggerInstance.InfoWithCtx(ctx, format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) DebugWith(format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelDebug, vars, func(loggerInstance logger.Logger) {
 		loggerInstance.DebugWith(format, vars...)
-	}
+	})
 }
 
 func (ml *MuxLogger) DebugWithCtx(ctx context.Context, format interface{}, vars ...interface{}) {
-	for _, loggerInstance := range ml.loggers {
+	ml.forward(logger.LevelDebug, vars, func(loggerInstance logger.Logger) {
 		loggerInstance.DebugWithCtx(ctx, format, vars...)
-	}
+	})
 }
 
+// Flush flushes the loggers, waiting for their queues to drain, for up to 5 seconds
 func (ml *MuxLogger) Flush() {
+	ml.FlushWithTimeout(muxDefaultFlushTimeout) // nolint: errcheck
+}
+
+// FlushWithTimeout flushes the loggers in parallel, waiting for their queues to drain. returns an error
+// if they didn't finish in time
+func (ml *MuxLogger) FlushWithTimeout(timeout time.Duration) error {
+	children, _ := ml.getChildren()
+
+	// buffered, so that loggers finishing after the timeout don't block
+	errsChan := make(chan error, len(children))
+	for _, child := range children {
+		go func(child *muxChild) {
+			errsChan <- ml.flushChild(child, timeout)
+		}(child)
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var errs []error
+	for childIndex := 0; childIndex < len(children); childIndex++ {
+		select {
+		case err := <-errsChan:
+			errs = append(errs, err)
+		case <-timer.C:
+			errs = append(errs, fmt.Errorf("timed out flushing %d loggers after %s",
+				len(children)-childIndex,
+				timeout))
+			return newMultiError(errs)
+		}
+	}
+
+	return newMultiError(errs)
+}
+
+// Close flushes the loggers, stops their queues and closes them (e.g. Loggerus closing its output),
+// returning their errors. when called on a child, it closes the loggers it was derived from. entries
+// logged afterwards are discarded, unless loggers are added again
+func (ml *MuxLogger) Close() error {
+	rootMuxLogger := ml.getRoot()
+
+	rootMuxLogger.lock.Lock()
+	children := rootMuxLogger.children
+	rootMuxLogger.setChildren(nil)
+	rootMuxLogger.lock.Unlock()
+
+	var errs []error
+	var closedLoggers []logger.Logger
+	for _, child := range children {
+		errs = append(errs, child.state.close(muxDefaultFlushTimeout))
+
+		for _, loggerInstance := range []logger.Logger{child.logger, child.fallback} {
+			if loggerInstance == nil || containsLogger(closedLoggers, loggerInstance) {
+				continue
+			}
+
+			closedLoggers = append(closedLoggers, loggerInstance)
+			errs = append(errs, closeLogger(loggerInstance))
+		}
+	}
+
+	return newMultiError(errs)
 }
 
 func (ml *MuxLogger) GetChild(name string) logger.Logger {
-	childLoggers := []logger.Logger{}
-	for _, loggerInstance := range ml.loggers {
-		childLogger := loggerInstance.GetChild(name)
-		childLoggers = append(childLoggers, childLogger)
+	childMuxLogger := MuxLogger{
+		name:      name,
+		childName: name,
+		parent:    ml,
+	}
+
+	if len(ml.name) > 0 {
+		childMuxLogger.name = ml.name + "." + name
+	}
+
+	// derive the current loggers now, later ones are derived on first use
+	childMuxLogger.getChildren()
+
+	return &childMuxLogger
+}
+
+// forwards an entry to the loggers whose options allow it. fieldVars are the key/value pairs of
+// structured entries
+func (ml *MuxLogger) forward(level logger.Level, fieldVars []interface{}, emit func(logger.Logger)) {
+	var fields map[string]interface{}
+
+	routingTable := ml.getRoutingTable()
+
+	var routedLoggerNames []string
+	if routingTable != nil {
+		routedLoggerNames = routingTable.route(ml.name, level, fieldVars, &fields)
+		if len(routedLoggerNames) == 0 {
+			return
+		}
+	}
+
+	children, _ := ml.getChildren()
+	for _, child := range children {
+		if routingTable != nil && !containsString(routedLoggerNames, child.options.Name) {
+			continue
+		}
+
+		if !child.options.allows(ml.name, level, fieldVars, &fields) {
+			continue
+		}
+
+		ml.forwardToChild(child, emit)
+	}
+}
+
+// a logger which panics, or can't keep up with its queue, doesn't affect the others - entries are sent
+// to its fallback instead, if any
+func (ml *MuxLogger) forwardToChild(child *muxChild, emit func(logger.Logger)) {
+	if child.fallback != nil && !child.state.isHealthy(&child.options) {
+		ml.forwardToFallback(child, emit)
+		return
+	}
+
+	queued, err := child.state.enqueue(func() {
+		if err := emitIsolated(child.logger, emit); err != nil {
+			child.state.reportFailure(err, false, &child.options)
+		}
+	}, child.options.Timeout)
+
+	if !queued {
+		err = emitIsolated(child.logger, emit)
+	}
+
+	if err != nil {
+		child.state.reportFailure(err, queued, &child.options)
+
+		if child.fallback != nil {
+			ml.forwardToFallback(child, emit)
+		}
+	}
+}
+
+func (ml *MuxLogger) forwardToFallback(child *muxChild, emit func(logger.Logger)) {
+	if err := emitIsolated(child.fallback, emit); err != nil {
+		reportMuxFailure(&child.options, fmt.Errorf("failed to forward log entry to fallback of logger %d, %w",
+			child.handle,
+			err))
+	}
+}
+
+func (ml *MuxLogger) flushChild(child *muxChild, timeout time.Duration) error {
+	err := child.state.flush(timeout)
+
+	for _, loggerInstance := range []logger.Logger{child.logger, child.fallback} {
+		if loggerInstance != nil {
+			emitIsolated(loggerInstance, logger.Logger.Flush) // nolint: errcheck
+		}
+	}
+
+	return err
+}
+
+func (ml *MuxLogger) getRoutingTable() *MuxRoutingTable {
+	rootMuxLogger := ml.getRoot()
+
+	rootMuxLogger.lock.RLock()
+	defer rootMuxLogger.lock.RUnlock()
+
+	return rootMuxLogger.routingTable
+}
+
+func (ml *MuxLogger) getRoot() *MuxLogger {
+	rootMuxLogger := ml
+	for rootMuxLogger.parent != nil {
+		rootMuxLogger = rootMuxLogger.parent
+	}
+
+	return rootMuxLogger
+}
+
+// returns the current loggers and their generation. children derive the loggers added to the root since
+// they were last called
+func (ml *MuxLogger) getChildren() ([]*muxChild, uint64) {
+	if ml.parent == nil {
+		ml.lock.RLock()
+		defer ml.lock.RUnlock()
+
+		return ml.children, ml.generation
+	}
+
+	parentChildren, parentGeneration := ml.parent.getChildren()
+
+	ml.lock.RLock()
+	children, generation := ml.children, ml.generation
+	ml.lock.RUnlock()
+
+	if children != nil && generation == parentGeneration {
+		return children, generation
+	}
+
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+
+	// loggers derived before are kept, so that they aren't created again on every change
+	derivedChildren := make(map[MuxLoggerHandle]*muxChild, len(ml.children))
+	for _, child := range ml.children {
+		derivedChildren[child.handle] = child
+	}
+
+	children = make([]*muxChild, 0, len(parentChildren))
+	for _, parentChild := range parentChildren {
+		derivedChild := &muxChild{
+			handle:            parentChild.handle,
+			options:           parentChild.options,
+			state:             parentChild.state,
+			optionsGeneration: parentChild.optionsGeneration,
+		}
+
+		previousDerivedChild, found := derivedChildren[parentChild.handle]
+		if found {
+			derivedChild.logger = previousDerivedChild.logger
+		} else {
+			derivedChild.logger = parentChild.logger.GetChild(ml.childName)
+		}
+
+		if parentChild.fallback != nil {
+			if found && previousDerivedChild.optionsGeneration == parentChild.optionsGeneration {
+				derivedChild.fallback = previousDerivedChild.fallback
+			} else {
+				derivedChild.fallback = parentChild.fallback.GetChild(ml.childName)
+			}
+		}
+
+		children = append(children, derivedChild)
+	}
+
+	ml.children = children
+	ml.generation = parentGeneration
+
+	return children, parentGeneration
+}
+
+// must be called on the root, with its lock held
+func (ml *MuxLogger) setChildren(children []*muxChild) {
+	if children == nil {
+		children = []*muxChild{}
+	}
+
+	ml.children = children
+	ml.generation++
+}
+
+func (ml *MuxLogger) setLoggerOptions(handle MuxLoggerHandle, options MuxLoggerOptions) error {
+	ml.lock.Lock()
+	defer ml.lock.Unlock()
+
+	children := make([]*muxChild, 0, len(ml.children))
+	found := false
+	for _, child := range ml.children {
+		if child.handle == handle {
+			child.state.setQueueSize(options.QueueSize)
+
+			child = &muxChild{
+				handle:            child.handle,
+				logger:            child.logger,
+				fallback:          options.Fallback,
+				options:           options,
+				state:             child.state,
+				optionsGeneration: ml.generation + 1,
+			}
+			found = true
+		}
+
+		children = append(children, child)
+	}
+
+	if !found {
+		return fmt.Errorf("logger with handle %d was removed", handle)
+	}
+
+	ml.setChildren(children)
+
+	return nil
+}
+
+func newMuxChild(handle MuxLoggerHandle, loggerInstance logger.Logger, options MuxLoggerOptions) *muxChild {
+	return &muxChild{
+		handle:   handle,
+		logger:   loggerInstance,
+		fallback: options.Fallback,
+		options:  options,
+		state:    newMuxChildState(handle, options.QueueSize),
+	}
+}
+
+func (o *MuxLoggerOptions) validate() error {
+	if o.QueueSize < 0 {
+		return fmt.Errorf("queue size must not be negative")
+	}
+
+	for _, pattern := range append(append([]string{}, o.IncludeNames...), o.ExcludeNames...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid logger name pattern %q, %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+// fields is populated from fieldVars on first use, and shared between the loggers of an entry
+func (o *MuxLoggerOptions) allows(name string,
+	level logger.Level,
+	fieldVars []interface{},
+	fields *map[string]interface{}) bool {
+
+	if level < o.Level {
+		return false
+	}
+
+	if len(o.IncludeNames) > 0 && !matchesAnyPattern(o.IncludeNames, name) {
+		return false
+	}
+
+	if matchesAnyPattern(o.ExcludeNames, name) {
+		return false
+	}
+
+	if len(o.FieldPredicates) == 0 {
+		return true
+	}
+
+	if *fields == nil && fieldVars != nil {
+		*fields = varsToMap(fieldVars)
+	}
+
+	for _, fieldPredicate := range o.FieldPredicates {
+		if !fieldPredicate(*fields) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func matchesAnyPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func varsToMap(vars []interface{}) map[string]interface{} {
+	fields := make(map[string]interface{}, len(vars)/2)
+
+	for varIndex := 0; varIndex+1 < len(vars); varIndex += 2 {
+		if key, ok := vars[varIndex].(string); ok {
+			fields[key] = vars[varIndex+1]
+		}
+	}
+
+	return fields
+}
+
+func containsLogger(loggers []logger.Logger, loggerInstance logger.Logger) bool {
+	for _, candidateLogger := range loggers {
+		if candidateLogger == loggerInstance {
+			return true
+		}
+	}
+
+	return false
+}
+
+// closes loggers which can be closed (e.g. Loggerus, MuxLogger), and flushes the rest
+func closeLogger(loggerInstance logger.Logger) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("logger panicked while closing, %v", recovered)
+		}
+	}()
+
+	if loggerCloser, ok := loggerInstance.(io.Closer); ok {
+		return loggerCloser.Close()
+	}
+
+	loggerInstance.Flush()
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, candidateValue := range values {
+		if candidateValue == value {
+			return true
+		}
 	}
-	childMuxLogger, _ := NewMuxLogger(childLoggers...)
 
-	return childMuxLogger
+	return false
 }
@@ -0,0 +1,433 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nuclio/logger"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+// an output which panics, or blocks until released
+type muxTestFaultyOutput struct {
+	panics      bool
+	releaseChan chan struct{}
+}
+
+func (o *muxTestFaultyOutput) Write(p []byte) (int, error) {
+	if o.panics {
+		panic("faulty output")
+	}
+
+	<-o.releaseChan
+	return len(p), nil
+}
+
+// a logger which can't be compared
+type muxTestUncomparableLogger struct {
+	*Loggerus
+	tags []string
+}
+
+type muxSuite struct {
+	suite.Suite
+}
+
+func (suite *muxSuite) TestForwardsToAll() {
+	firstLogger, firstOutput := suite.createLogger()
+	secondLogger, secondOutput := suite.createLogger()
+
+	muxLogger, err := NewMuxLogger(firstLogger, secondLogger)
+	suite.Require().NoError(err)
+
+	muxLogger.Debug("Unstructured")
+	muxLogger.GetChild("child").InfoWith("Structured", "key", "value")
+
+	for _, output := range []*testOutput{firstOutput, secondOutput} {
+		suite.Require().Equal([]string{":Unstructured", "child:Structured"}, output.getEntries())
+	}
+}
+
+func (suite *muxSuite) TestLoggerOptions() {
+	localLogger, localOutput := suite.createLogger()
+	remoteLogger, remoteOutput := suite.createLogger()
+	auditLogger, auditOutput := suite.createLogger()
+
+	muxLogger, err := NewMuxLogger(localLogger, remoteLogger, auditLogger)
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(muxLogger.SetLoggerOptions(remoteLogger, MuxLoggerOptions{
+		Level:        logger.LevelWarn,
+		IncludeNames: []string{"controller", "controller.*"},
+		ExcludeNames: []string{"controller.noisy"},
+	}))
+
+	suite.Require().NoError(muxLogger.SetLoggerOptions(auditLogger, MuxLoggerOptions{
+		FieldPredicates: []MuxFieldPredicate{
+			func(fields map[string]interface{}) bool {
+				return fields["audit"] == true
+			},
+		},
+	}))
+
+	controllerLogger := muxLogger.GetChild("controller")
+	controllerLogger.InfoWith("Info")
+	controllerLogger.WarnWith("Warning")
+	controllerLogger.GetChild("worker").ErrorWith("Worker error")
+	controllerLogger.GetChild("noisy").ErrorWith("Noisy error")
+	muxLogger.GetChild("processor").ErrorWith("Processor error")
+	muxLogger.InfoWith("Audited", "audit", true)
+	muxLogger.InfoWith("Not audited", "audit", false)
+
+	suite.Require().Len(localOutput.getEntries(), 7)
+	suite.Require().Equal([]string{
+		"controller:Warning",
+		"controller.worker:Worker error",
+	}, remoteOutput.getEntries())
+	suite.Require().Equal([]string{":Audited"}, auditOutput.getEntries())
+}
+
+func (suite *muxSuite) TestInvalidLoggerOptions() {
+	loggerInstance, _ := suite.createLogger()
+	otherLoggerInstance, _ := suite.createLogger()
+
+	muxLogger, err := NewMuxLogger(loggerInstance)
+	suite.Require().NoError(err)
+
+	suite.Require().Error(muxLogger.SetLoggerOptions(loggerInstance, MuxLoggerOptions{IncludeNames: []string{"["}}))
+	suite.Require().Error(muxLogger.SetLoggerOptions(otherLoggerInstance, MuxLoggerOptions{}))
+}
+
+func (suite *muxSuite) TestAddAndRemoveLogger() {
+	loggerInstance, output := suite.createLogger()
+
+	muxLogger, err := NewMuxLogger(loggerInstance)
+	suite.Require().NoError(err)
+
+	childLogger := muxLogger.GetChild("child")
+	grandchildLogger := childLogger.GetChild("grandchild")
+
+	// added after the children were created, through a child
+	debugLogger, debugOutput := suite.createLogger()
+	handle, err := childLogger.(*MuxLogger).AddLogger(debugLogger, MuxLoggerOptions{})
+	suite.Require().NoError(err)
+
+	grandchildLogger.InfoWith("Added")
+	suite.Require().Equal([]string{"child.grandchild:Added"}, debugOutput.getEntries())
+	suite.Require().Len(muxLogger.GetLoggers(), 2)
+
+	suite.Require().NoError(muxLogger.RemoveLogger(handle))
+	suite.Require().Error(muxLogger.RemoveLogger(handle))
+
+	grandchildLogger.InfoWith("Removed")
+	suite.Require().Len(debugOutput.getEntries(), 1)
+	suite.Require().Equal([]string{"child.grandchild:Added", "child.grandchild:Removed"}, output.getEntries())
+
+	// options set through a child apply to the logger derived for it
+	suite.Require().NoError(childLogger.(*MuxLogger).SetLoggerOptions(childLogger.(*MuxLogger).GetLoggers()[0],
+		MuxLoggerOptions{Level: logger.LevelError}))
+
+	grandchildLogger.InfoWith("Filtered")
+	suite.Require().Len(output.getEntries(), 2)
+}
+
+func (suite *muxSuite) TestConcurrentChanges() {
+	muxLogger, err := NewMuxLogger()
+	suite.Require().NoError(err)
+
+	childLogger := muxLogger.GetChild("child")
+
+	stopChan := make(chan struct{})
+	loggingDone := sync.WaitGroup{}
+	for goroutineIndex := 0; goroutineIndex < 4; goroutineIndex++ {
+		loggingDone.Add(1)
+		go func() {
+			defer loggingDone.Done()
+
+			for {
+				select {
+				case <-stopChan:
+					return
+				default:
+					childLogger.InfoWith("Concurrent")
+					childLogger.GetChild("grandchild").DebugWith("Concurrent")
+				}
+			}
+		}()
+	}
+
+	for iteration := 0; iteration < 100; iteration++ {
+		loggerInstance, _ := suite.createLogger()
+
+		handle, err := muxLogger.AddLogger(loggerInstance, MuxLoggerOptions{})
+		suite.Require().NoError(err)
+
+		if iteration%2 == 0 {
+			suite.Require().NoError(muxLogger.RemoveLogger(handle))
+		}
+	}
+
+	close(stopChan)
+	loggingDone.Wait()
+
+	suite.Require().Len(childLogger.(*MuxLogger).GetLoggers(), 50)
+}
+
+func (suite *muxSuite) TestPanicIsolation() {
+	panickingLogger, err := NewJSONLoggerus("", logrus.DebugLevel, &muxTestFaultyOutput{panics: true})
+	suite.Require().NoError(err)
+
+	healthyLogger, healthyOutput := suite.createLogger()
+	fallbackLogger, fallbackOutput := suite.createLogger()
+
+	muxLogger, err := NewMuxLogger(healthyLogger)
+	suite.Require().NoError(err)
+
+	var reportedErrors []error
+	_, err = muxLogger.AddLogger(panickingLogger, MuxLoggerOptions{
+		Fallback: fallbackLogger,
+		ErrorHandler: func(err error) {
+			reportedErrors = append(reportedErrors, err)
+		},
+	})
+	suite.Require().NoError(err)
+
+	muxLogger.InfoWith("First")
+	muxLogger.GetChild("child").InfoWith("Second")
+
+	// once per failure streak
+	suite.Require().Len(reportedErrors, 1)
+	suite.Require().Contains(reportedErrors[0].Error(), "faulty output")
+
+	suite.Require().Equal([]string{":First", "child:Second"}, healthyOutput.getEntries())
+
+	// the entry which panicked and the one sent while unhealthy, derived through GetChild
+	suite.Require().Equal([]string{":First", "child:Second"}, fallbackOutput.getEntries())
+
+	health, err := muxLogger.GetLoggerHealth(panickingLogger)
+	suite.Require().NoError(err)
+	suite.Require().False(health.Healthy)
+	suite.Require().Equal(uint64(1), health.Panics)
+	suite.Require().Contains(health.LastError.Error(), "faulty output")
+
+	health, err = muxLogger.GetLoggerHealth(healthyLogger)
+	suite.Require().NoError(err)
+	suite.Require().True(health.Healthy)
+}
+
+func (suite *muxSuite) TestChangeUncomparableFallback() {
+	panickingLogger, err := NewJSONLoggerus("", logrus.DebugLevel, &muxTestFaultyOutput{panics: true})
+	suite.Require().NoError(err)
+
+	firstFallbackLogger, firstFallbackOutput := suite.createLogger()
+	secondFallbackLogger, secondFallbackOutput := suite.createLogger()
+
+	muxLogger, err := NewMuxLogger()
+	suite.Require().NoError(err)
+
+	_, err = muxLogger.AddLogger(panickingLogger, MuxLoggerOptions{
+		Fallback:     muxTestUncomparableLogger{Loggerus: firstFallbackLogger},
+		ErrorHandler: func(error) {},
+	})
+	suite.Require().NoError(err)
+
+	childLogger := muxLogger.GetChild("child")
+	childLogger.InfoWith("First")
+
+	suite.Require().NoError(muxLogger.SetLoggerOptions(panickingLogger, MuxLoggerOptions{
+		Fallback:     muxTestUncomparableLogger{Loggerus: secondFallbackLogger},
+		ErrorHandler: func(error) {},
+	}))
+
+	childLogger.InfoWith("Second")
+
+	suite.Require().Equal([]string{"child:First"}, firstFallbackOutput.getEntries())
+	suite.Require().Equal([]string{"child:Second"}, secondFallbackOutput.getEntries())
+}
+
+func (suite *muxSuite) TestQueueTimeoutAndFailover() {
+	blockingOutput := &muxTestFaultyOutput{releaseChan: make(chan struct{})}
+	blockingLogger, err := NewJSONLoggerus("", logrus.DebugLevel, blockingOutput)
+	suite.Require().NoError(err)
+
+	fallbackLogger, fallbackOutput := suite.createLogger()
+
+	muxLogger, err := NewMuxLogger()
+	suite.Require().NoError(err)
+
+	handle, err := muxLogger.AddLogger(blockingLogger, MuxLoggerOptions{
+		QueueSize:     1,
+		Timeout:       10 * time.Millisecond,
+		Fallback:      fallbackLogger,
+		RetryInterval: 50 * time.Millisecond,
+	})
+	suite.Require().NoError(err)
+
+	// the first blocks the worker, the second fills the queue, the third is dropped after the timeout
+	// and goes to the fallback, as does the fourth since the logger is now unhealthy
+	for _, message := range []string{"First", "Second", "Third", "Fourth"} {
+		muxLogger.InfoWith(message)
+	}
+
+	suite.Require().Equal([]string{":Third", ":Fourth"}, fallbackOutput.getEntries())
+
+	health, err := muxLogger.GetLoggerHealth(blockingLogger)
+	suite.Require().NoError(err)
+	suite.Require().False(health.Healthy)
+	suite.Require().Equal(uint64(1), health.Dropped)
+	suite.Require().Equal(1, health.QueueLength)
+
+	// once released and past the retry interval, entries go to the primary again
+	close(blockingOutput.releaseChan)
+	suite.Require().Eventually(func() bool {
+		health, _ := muxLogger.GetLoggerHealth(blockingLogger)
+		return health.Healthy && health.QueueLength == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	muxLogger.InfoWith("Fifth")
+	suite.Require().Len(fallbackOutput.getEntries(), 2)
+
+	suite.Require().NoError(muxLogger.RemoveLogger(handle))
+}
+
+func (suite *muxSuite) TestResizeQueueWhileWaitingForRoom() {
+	blockingOutput := &muxTestFaultyOutput{releaseChan: make(chan struct{})}
+	blockingLogger, err := NewJSONLoggerus("", logrus.DebugLevel, blockingOutput)
+	suite.Require().NoError(err)
+
+	muxLogger, err := NewMuxLogger()
+	suite.Require().NoError(err)
+
+	_, err = muxLogger.AddLogger(blockingLogger, MuxLoggerOptions{QueueSize: 1, Timeout: 5 * time.Second})
+	suite.Require().NoError(err)
+
+	// the first blocks the worker, the second fills the queue and the third waits for room
+	muxLogger.InfoWith("First")
+	muxLogger.InfoWith("Second")
+
+	waitingDone := make(chan struct{})
+	go func() {
+		defer close(waitingDone)
+		muxLogger.InfoWith("Third")
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+
+	// resizing the queue doesn't wait for the sender
+	resizeStartTime := time.Now()
+	suite.Require().NoError(muxLogger.SetLoggerOptions(blockingLogger, MuxLoggerOptions{QueueSize: 2}))
+	suite.Require().Less(int64(time.Since(resizeStartTime)), int64(time.Second))
+
+	close(blockingOutput.releaseChan)
+	<-waitingDone
+
+	suite.Require().NoError(muxLogger.FlushWithTimeout(5 * time.Second))
+	suite.Require().NoError(muxLogger.Close())
+}
+
+func (suite *muxSuite) TestFlushWithTimeout() {
+	closingOutput := &testClosingOutput{}
+	closingLogger, err := NewJSONLoggerus("", logrus.DebugLevel, closingOutput)
+	suite.Require().NoError(err)
+
+	blockingOutput := &muxTestFaultyOutput{releaseChan: make(chan struct{})}
+	blockingLogger, err := NewJSONLoggerus("", logrus.DebugLevel, blockingOutput)
+	suite.Require().NoError(err)
+
+	muxLogger, err := NewMuxLogger(closingLogger)
+	suite.Require().NoError(err)
+
+	_, err = muxLogger.AddLogger(blockingLogger, MuxLoggerOptions{QueueSize: 10})
+	suite.Require().NoError(err)
+
+	muxLogger.InfoWith("Blocked")
+
+	// the blocked queue doesn't drain in time, the other logger is flushed regardless
+	suite.Require().Error(muxLogger.GetChild("child").(*MuxLogger).FlushWithTimeout(50 * time.Millisecond))
+	suite.Require().Equal(1, closingOutput.getFlushes())
+
+	close(blockingOutput.releaseChan)
+	suite.Require().NoError(muxLogger.FlushWithTimeout(5 * time.Second))
+	suite.Require().NoError(muxLogger.Close())
+}
+
+func (suite *muxSuite) TestClose() {
+	firstOutput := &testClosingOutput{}
+	firstLogger, err := NewJSONLoggerus("", logrus.DebugLevel, firstOutput)
+	suite.Require().NoError(err)
+
+	secondOutput := &testClosingOutput{closeErr: &os.PathError{Op: "close", Path: "second", Err: errors.New("second failed")}}
+	secondLogger, err := NewJSONLoggerus("", logrus.DebugLevel, NewRedactor(secondOutput))
+	suite.Require().NoError(err)
+
+	thirdErr := errors.New("third failed")
+	thirdOutput := &testClosingOutput{closeErr: thirdErr}
+	thirdLogger, err := NewJSONLoggerus("", logrus.DebugLevel, thirdOutput)
+	suite.Require().NoError(err)
+
+	muxLogger, err := NewMuxLogger(firstLogger, secondLogger)
+	suite.Require().NoError(err)
+
+	// queued entries are forwarded before closing, and the fallback is closed once
+	_, err = muxLogger.AddLogger(thirdLogger, MuxLoggerOptions{QueueSize: 10, Fallback: firstLogger})
+	suite.Require().NoError(err)
+
+	childLogger := muxLogger.GetChild("child")
+	for index := 0; index < 5; index++ {
+		childLogger.InfoWith("Queued")
+	}
+
+	err = childLogger.(*MuxLogger).Close()
+	suite.Require().Error(err)
+	suite.Require().Len(err.(MultiError).Errors(), 2)
+	suite.Require().True(errors.Is(err, thirdErr))
+
+	var pathErr *os.PathError
+	suite.Require().True(errors.As(err, &pathErr))
+	suite.Require().Equal("second", pathErr.Path)
+	suite.Require().Contains(err.Error(), "second failed")
+	suite.Require().Contains(err.Error(), "third failed")
+
+	for _, output := range []*testClosingOutput{firstOutput, secondOutput, thirdOutput} {
+		suite.Require().True(output.closed)
+		suite.Require().Len(output.getEntries(), 5)
+	}
+
+	// discarded
+	muxLogger.InfoWith("Closed")
+	suite.Require().Len(firstOutput.getEntries(), 5)
+	suite.Require().Empty(muxLogger.GetLoggers())
+}
+
+func (suite *muxSuite) createLogger() (*Loggerus, *testOutput) {
+	output := &testOutput{}
+
+	loggerInstance, err := NewJSONLoggerus("", logrus.DebugLevel, output)
+	suite.Require().NoError(err)
+
+	return loggerInstance, output
+}
+
+func TestMuxTestSuite(t *testing.T) {
+	suite.Run(t, new(muxSuite))
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nuclio/logger"
+)
+
+const (
+	muxDefaultRetryInterval = 10 * time.Second
+	muxDefaultFlushTimeout  = 5 * time.Second
+)
+
+type MuxLoggerHealth struct {
+
+	// false if the logger failed within the last RetryInterval, or is stuck in a call for longer than Timeout
+	Healthy bool
+
+	// the last failure - a panic, or an entry dropped because the queue was full
+	LastError     error
+	LastErrorTime time.Time
+
+	Panics  uint64
+	Dropped uint64
+
+	// entries waiting to be forwarded, when forwarding through a queue
+	QueueLength int
+}
+
+// the health and queue of a logger of a MuxLogger, shared by the loggers derived from it through GetChild
+type muxChildState struct {
+	handle MuxLoggerHandle
+
+	lock           sync.Mutex
+	health         MuxLoggerHealth
+	unhealthyUntil time.Time
+	busySince      time.Time
+
+	// guards replacing the queue. senders only hold it to register with the queue, not while waiting for room
+	queueLock sync.RWMutex
+	queue     *muxChildQueue
+}
+
+// the entries a worker forwards to a logger. once replaced, the worker forwards the entries left in it and
+// those of senders which registered before it was replaced, and exits
+type muxChildQueue struct {
+	entries chan func()
+	senders sync.WaitGroup
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newMuxChildState(handle MuxLoggerHandle, queueSize int) *muxChildState {
+	newMuxChildState := muxChildState{
+		handle: handle,
+	}
+
+	newMuxChildState.setQueueSize(queueSize)
+
+	return &newMuxChildState
+}
+
+// replaces the queue - the worker of the previous one exits once it forwarded the entries left in it
+func (s *muxChildState) setQueueSize(queueSize int) {
+	s.queueLock.Lock()
+	defer s.queueLock.Unlock()
+
+	if s.queue != nil {
+		if cap(s.queue.entries) == queueSize {
+			return
+		}
+
+		close(s.queue.stop)
+		s.queue = nil
+	}
+
+	if queueSize > 0 {
+		s.queue = &muxChildQueue{
+			entries: make(chan func(), queueSize),
+			stop:    make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+
+		go s.processQueue(s.queue)
+	}
+}
+
+// returns the queue (nil if there's none), registered as a sender to it until the returned func is called
+func (s *muxChildState) registerSender() (*muxChildQueue, func()) {
+	s.queueLock.RLock()
+	defer s.queueLock.RUnlock()
+
+	if s.queue == nil {
+		return nil, func() {}
+	}
+
+	queue := s.queue
+	queue.senders.Add(1)
+
+	return queue, queue.senders.Done
+}
+
+// stops the worker of the queue, if any
+func (s *muxChildState) stop() {
+	s.setQueueSize(0)
+}
+
+// returns false if the logger isn't forwarded to through a queue, and an error if the queue stayed
+// full for the timeout
+func (s *muxChildState) enqueue(emit func(), timeout time.Duration) (bool, error) {
+	queue, unregisterSender := s.registerSender()
+	defer unregisterSender()
+
+	if queue == nil {
+		return false, nil
+	}
+
+	select {
+	case queue.entries <- emit:
+		return true, nil
+	default:
+	}
+
+	// without holding the queue lock, so that replacing the queue doesn't wait for senders
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+
+		select {
+		case queue.entries <- emit:
+			return true, nil
+		case <-timer.C:
+		}
+	}
+
+	return true, fmt.Errorf("queue of %d entries is full", cap(queue.entries))
+}
+
+// blocks until the entries queued so far were forwarded, or the timeout passes
+func (s *muxChildState) flush(timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	flushedChan := make(chan struct{})
+
+	queue, unregisterSender := s.registerSender()
+	if queue == nil {
+		unregisterSender()
+		return nil
+	}
+
+	select {
+	case queue.entries <- func() { close(flushedChan) }:
+		unregisterSender()
+	case <-timer.C:
+		unregisterSender()
+		return fmt.Errorf("timed out waiting for room in the queue of logger %d", s.handle)
+	}
+
+	select {
+	case <-flushedChan:
+		return nil
+	case <-timer.C:
+		return fmt.Errorf("timed out waiting for the queue of logger %d to drain", s.handle)
+	}
+}
+
+// forwards the queued entries and stops the worker, waiting up to the timeout for it to exit
+func (s *muxChildState) close(timeout time.Duration) error {
+	flushErr := s.flush(timeout)
+
+	s.queueLock.RLock()
+	queue := s.queue
+	s.queueLock.RUnlock()
+
+	s.stop()
+
+	if queue == nil || flushErr != nil {
+		return flushErr
+	}
+
+	select {
+	case <-queue.done:
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out waiting for the queue of logger %d to stop", s.handle)
+	}
+}
+
+func (s *muxChildState) processQueue(queue *muxChildQueue) {
+	defer close(queue.done)
+
+	for replaced := false; !replaced; {
+		select {
+		case emit := <-queue.entries:
+			s.emitQueued(emit)
+		case <-queue.stop:
+			replaced = true
+		}
+	}
+
+	// no sender registers once the queue was replaced, so it's closed once those registered are done
+	go func() {
+		queue.senders.Wait()
+		close(queue.entries)
+	}()
+
+	for emit := range queue.entries {
+		s.emitQueued(emit)
+	}
+}
+
+func (s *muxChildState) emitQueued(emit func()) {
+	s.lock.Lock()
+	s.busySince = time.Now()
+	s.lock.Unlock()
+
+	emit()
+
+	s.lock.Lock()
+	s.busySince = time.Time{}
+	s.lock.Unlock()
+}
+
+func (s *muxChildState) reportFailure(err error, dropped bool, options *MuxLoggerOptions) {
+	s.lock.Lock()
+
+	now := time.Now()
+	wasHealthy := s.isHealthyLocked(now, options)
+
+	if dropped {
+		s.health.Dropped++
+	} else {
+		s.health.Panics++
+	}
+
+	s.health.LastError = err
+	s.health.LastErrorTime = now
+
+	retryInterval := options.RetryInterval
+	if retryInterval == 0 {
+		retryInterval = muxDefaultRetryInterval
+	}
+
+	s.unhealthyUntil = now.Add(retryInterval)
+
+	s.lock.Unlock()
+
+	// once per failure streak, rather than for every entry. called without holding the lock, since the handler
+	// may log through the MuxLogger
+	if wasHealthy {
+		reportMuxFailure(options, fmt.Errorf("failed to forward log entry to logger %d, %w", s.handle, err))
+	}
+}
+
+// reports a failure with the error handler of the logger, or to stderr if it has none
+func reportMuxFailure(options *MuxLoggerOptions, err error) {
+	if options.ErrorHandler != nil {
+		options.ErrorHandler(err)
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "%v\n", err) // nolint: errcheck
+}
+
+func (s *muxChildState) isHealthy(options *MuxLoggerOptions) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.isHealthyLocked(time.Now(), options)
+}
+
+func (s *muxChildState) isHealthyLocked(now time.Time, options *MuxLoggerOptions) bool {
+	if now.Before(s.unhealthyUntil) {
+		return false
+	}
+
+	if options.Timeout > 0 && !s.busySince.IsZero() && now.Sub(s.busySince) > options.Timeout {
+		return false
+	}
+
+	return true
+}
+
+func (s *muxChildState) getHealth(options *MuxLoggerOptions) MuxLoggerHealth {
+	s.queueLock.RLock()
+	queueLength := 0
+	if s.queue != nil {
+		queueLength = len(s.queue.entries)
+	}
+	s.queueLock.RUnlock()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	health := s.health
+	health.Healthy = s.isHealthyLocked(time.Now(), options)
+	health.QueueLength = queueLength
+
+	return health
+}
+
+// calls emit with the logger, returning an error if it panicked
+func emitIsolated(loggerInstance logger.Logger, emit func(logger.Logger)) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("logger panicked, %v", recovered)
+		}
+	}()
+
+	emit(loggerInstance)
+
+	return nil
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/nuclio/logger"
+	"gopkg.in/yaml.v3"
+)
+
+type MuxRoutingMode int
+
+const (
+
+	// entries are routed to the loggers of every matching route
+	MuxRoutingAllMatches MuxRoutingMode = iota
+
+	// entries are routed to the loggers of the first matching route only
+	MuxRoutingFirstMatch
+)
+
+// routes the entries matching all of its conditions (unset conditions always match) to loggers
+type MuxRoute struct {
+
+	// glob patterns (as in path.Match) of logger names, one of which must match
+	Names []string
+
+	// minimum level
+	Level logger.Level
+
+	// fields which structured entries must have, with these values (compared in their string form)
+	Fields map[string]string
+
+	// names of the loggers (see MuxLoggerOptions.Name) to which the matching entries are routed
+	Loggers []string
+}
+
+// decides to which loggers of a MuxLogger entries are forwarded, by their name, level and fields. the
+// options of each logger still apply to the entries routed to it
+type MuxRoutingTable struct {
+	Mode   MuxRoutingMode
+	Routes []MuxRoute
+
+	// names of the loggers to which entries matching no route are routed (if empty, they're dropped)
+	DefaultLoggers []string
+}
+
+// the rules file format, e.g.:
+//
+//	mode: first-match
+//	routes:
+//	  - names: ["controller.*"]
+//	    loggers: [local]
+//	  - fields: {audit: "true"}
+//	    loggers: [audit]
+//	  - level: error
+//	    loggers: [alerts]
+//	default: [local]
+type muxRoutingTableFile struct {
+	Mode   string `yaml:"mode"`
+	Routes []struct {
+		Names   []string          `yaml:"names"`
+		Level   string            `yaml:"level"`
+		Fields  map[string]string `yaml:"fields"`
+		Loggers []string          `yaml:"loggers"`
+	} `yaml:"routes"`
+	Default []string `yaml:"default"`
+}
+
+// LoadMuxRoutingTable reads a routing table from a YAML (or JSON) rules file
+func LoadMuxRoutingTable(filePath string) (*MuxRoutingTable, error) {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read routing rules, %w", err)
+	}
+
+	routingTableFile := muxRoutingTableFile{}
+	if err := yaml.Unmarshal(contents, &routingTableFile); err != nil {
+		return nil, fmt.Errorf("failed to parse routing rules in %s, %w", filePath, err)
+	}
+
+	routingTable := MuxRoutingTable{
+		DefaultLoggers: routingTableFile.Default,
+	}
+
+	switch routingTableFile.Mode {
+	case "", "all-match":
+		routingTable.Mode = MuxRoutingAllMatches
+	case "first-match":
+		routingTable.Mode = MuxRoutingFirstMatch
+	default:
+		return nil, fmt.Errorf("unknown routing mode %q", routingTableFile.Mode)
+	}
+
+	for routeIndex, fileRoute := range routingTableFile.Routes {
+		level, err := parseMuxLevel(fileRoute.Level)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level of route %d, %w", routeIndex, err)
+		}
+
+		routingTable.Routes = append(routingTable.Routes, MuxRoute{
+			Names:   fileRoute.Names,
+			Level:   level,
+			Fields:  fileRoute.Fields,
+			Loggers: fileRoute.Loggers,
+		})
+	}
+
+	if err := routingTable.validate(); err != nil {
+		return nil, err
+	}
+
+	return &routingTable, nil
+}
+
+func parseMuxLevel(level string) (logger.Level, error) {
+	switch strings.ToLower(level) {
+	case "", "debug":
+		return logger.LevelDebug, nil
+	case "info":
+		return logger.LevelInfo, nil
+	case "warn", "warning":
+		return logger.LevelWarn, nil
+	case "error":
+		return logger.LevelError, nil
+	default:
+		return 0, fmt.Errorf("unknown level %q", level)
+	}
+}
+
+// returns a deep copy of the routing table
+func (rt *MuxRoutingTable) clone() *MuxRoutingTable {
+	clonedRoutingTable := MuxRoutingTable{
+		Mode:           rt.Mode,
+		DefaultLoggers: append([]string{}, rt.DefaultLoggers...),
+	}
+
+	for _, route := range rt.Routes {
+		clonedRoute := MuxRoute{
+			Names:   append([]string{}, route.Names...),
+			Level:   route.Level,
+			Loggers: append([]string{}, route.Loggers...),
+		}
+
+		if route.Fields != nil {
+			clonedRoute.Fields = make(map[string]string, len(route.Fields))
+			for fieldName, fieldValue := range route.Fields {
+				clonedRoute.Fields[fieldName] = fieldValue
+			}
+		}
+
+		clonedRoutingTable.Routes = append(clonedRoutingTable.Routes, clonedRoute)
+	}
+
+	return &clonedRoutingTable
+}
+
+func (rt *MuxRoutingTable) validate() error {
+	for routeIndex, route := range rt.Routes {
+		for _, pattern := range route.Names {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid logger name pattern %q of route %d, %w", pattern, routeIndex, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// verifies that the routing table routes to known loggers only
+func (rt *MuxRoutingTable) validateLoggerNames(loggerNames map[string]bool) error {
+	for routeIndex, route := range rt.Routes {
+		for _, loggerName := range route.Loggers {
+			if !loggerNames[loggerName] {
+				return fmt.Errorf("unknown logger %q of route %d", loggerName, routeIndex)
+			}
+		}
+	}
+
+	for _, loggerName := range rt.DefaultLoggers {
+		if !loggerNames[loggerName] {
+			return fmt.Errorf("unknown default logger %q", loggerName)
+		}
+	}
+
+	return nil
+}
+
+// returns the names of the loggers to which an entry is routed
+func (rt *MuxRoutingTable) route(name string,
+	level logger.Level,
+	fieldVars []interface{},
+	fields *map[string]interface{}) []string {
+
+	var loggerNames []string
+	matched := false
+
+	for routeIndex := range rt.Routes {
+		route := &rt.Routes[routeIndex]
+		if !route.matches(name, level, fieldVars, fields) {
+			continue
+		}
+
+		if rt.Mode == MuxRoutingFirstMatch {
+			return route.Loggers
+		}
+
+		matched = true
+		loggerNames = append(loggerNames, route.Loggers...)
+	}
+
+	if !matched {
+		return rt.DefaultLoggers
+	}
+
+	return loggerNames
+}
+
+func (r *MuxRoute) matches(name string,
+	level logger.Level,
+	fieldVars []interface{},
+	fields *map[string]interface{}) bool {
+
+	if level < r.Level {
+		return false
+	}
+
+	if len(r.Names) > 0 && !matchesAnyPattern(r.Names, name) {
+		return false
+	}
+
+	if len(r.Fields) == 0 {
+		return true
+	}
+
+	if *fields == nil && fieldVars != nil {
+		*fields = varsToMap(fieldVars)
+	}
+
+	for fieldKey, fieldValue := range r.Fields {
+		entryFieldValue, found := (*fields)[fieldKey]
+		if !found || getFieldValueString(entryFieldValue) != fieldValue {
+			return false
+		}
+	}
+
+	return true
+}
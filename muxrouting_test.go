@@ -0,0 +1,162 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/nuclio/logger"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type muxRoutingSuite struct {
+	suite.Suite
+	muxLogger *MuxLogger
+	outputs   map[string]*testOutput
+}
+
+func (suite *muxRoutingSuite) SetupTest() {
+	var err error
+
+	suite.muxLogger, err = NewMuxLogger()
+	suite.Require().NoError(err)
+
+	suite.outputs = map[string]*testOutput{}
+	for _, name := range []string{"local", "audit", "alerts"} {
+		suite.outputs[name] = &testOutput{}
+
+		loggerInstance, err := NewJSONLoggerus("", logrus.DebugLevel, suite.outputs[name])
+		suite.Require().NoError(err)
+
+		_, err = suite.muxLogger.AddLogger(loggerInstance, MuxLoggerOptions{Name: name})
+		suite.Require().NoError(err)
+	}
+}
+
+func (suite *muxRoutingSuite) TestAllMatches() {
+	routingTable := &MuxRoutingTable{
+		Mode: MuxRoutingAllMatches,
+		Routes: []MuxRoute{
+			{Names: []string{"controller", "controller.*"}, Loggers: []string{"local"}},
+			{Fields: map[string]string{"audit": "true"}, Loggers: []string{"audit"}},
+			{Level: logger.LevelError, Loggers: []string{"alerts"}},
+		},
+	}
+
+	suite.Require().NoError(suite.muxLogger.SetRoutingTable(routingTable))
+
+	// the mux logger routes by a copy, which modifying the table doesn't affect
+	routingTable.Routes[0].Names[1] = "processor"
+	routingTable.Routes[1].Fields["audit"] = "false"
+	routingTable.Routes[2].Loggers[0] = "local"
+
+	suite.logEntries()
+
+	suite.Require().Equal([]string{"controller:Info", "controller.worker:Audited error"},
+		suite.outputs["local"].getEntries())
+	suite.Require().Equal([]string{"controller.worker:Audited error", "processor:Audited"},
+		suite.outputs["audit"].getEntries())
+	suite.Require().Equal([]string{"controller.worker:Audited error", "processor:Error"},
+		suite.outputs["alerts"].getEntries())
+}
+
+func (suite *muxRoutingSuite) TestFirstMatchFromFile() {
+	tempDir, err := ioutil.TempDir("", "loggerus-routing-")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(tempDir) // nolint: errcheck
+
+	rulesPath := filepath.Join(tempDir, "routes.yaml")
+	suite.Require().NoError(ioutil.WriteFile(rulesPath, []byte(`
+mode: first-match
+routes:
+  - names: ["controller", "controller.*"]
+    loggers: [local]
+  - fields: {audit: "true"}
+    loggers: [audit]
+  - level: error
+    loggers: [alerts]
+default: [local]
+`), 0644))
+
+	routingTable, err := LoadMuxRoutingTable(rulesPath)
+	suite.Require().NoError(err)
+	suite.Require().NoError(suite.muxLogger.SetRoutingTable(routingTable))
+
+	suite.logEntries()
+
+	// the processor's info entry matched no route, so it went to the default
+	suite.Require().Equal([]string{"controller:Info", "controller.worker:Audited error", "processor:Info"},
+		suite.outputs["local"].getEntries())
+	suite.Require().Equal([]string{"processor:Audited"}, suite.outputs["audit"].getEntries())
+	suite.Require().Equal([]string{"processor:Error"}, suite.outputs["alerts"].getEntries())
+
+	// back to broadcasting
+	suite.Require().NoError(suite.muxLogger.SetRoutingTable(nil))
+	suite.muxLogger.InfoWith("Broadcast")
+	suite.Require().Len(suite.outputs["alerts"].getEntries(), 2)
+}
+
+func (suite *muxRoutingSuite) TestUnknownLoggers() {
+	for _, routingTable := range []*MuxRoutingTable{
+		{Routes: []MuxRoute{{Loggers: []string{"local", "remote"}}}},
+		{DefaultLoggers: []string{"remote"}},
+	} {
+		suite.Require().Error(suite.muxLogger.SetRoutingTable(routingTable))
+	}
+
+	// the previous routing table (none) is kept
+	suite.muxLogger.InfoWith("Broadcast")
+	suite.Require().Len(suite.outputs["alerts"].getEntries(), 1)
+}
+
+func (suite *muxRoutingSuite) TestInvalidFile() {
+	tempDir, err := ioutil.TempDir("", "loggerus-routing-")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(tempDir) // nolint: errcheck
+
+	for _, contents := range []string{
+		"mode: some-match",
+		"routes: [{level: fatal}]",
+		"routes: [{names: ['[']}]",
+		"routes: {",
+	} {
+		rulesPath := filepath.Join(tempDir, "routes.yaml")
+		suite.Require().NoError(ioutil.WriteFile(rulesPath, []byte(contents), 0644))
+
+		_, err = LoadMuxRoutingTable(rulesPath)
+		suite.Require().Error(err, contents)
+	}
+}
+
+func (suite *muxRoutingSuite) logEntries() {
+	controllerLogger := suite.muxLogger.GetChild("controller")
+	controllerLogger.InfoWith("Info")
+	controllerLogger.GetChild("worker").ErrorWith("Audited error", "audit", true)
+
+	processorLogger := suite.muxLogger.GetChild("processor")
+	processorLogger.InfoWith("Info")
+	processorLogger.InfoWith("Audited", "audit", true)
+	processorLogger.ErrorWith("Error")
+}
+
+func TestMuxRoutingTestSuite(t *testing.T) {
+	suite.Run(t, new(muxRoutingSuite))
+}
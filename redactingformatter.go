@@ -0,0 +1,399 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	redactingFormatterDefaultReplacement = "[redacted]"
+	redactingFormatterWildcard           = "*"
+
+	// deeper values (e.g. of cyclic pointers) are left as is
+	redactingFormatterMaxDepth = 32
+)
+
+type RedactingFormatterConfig struct {
+
+	// dot separated paths of the values to redact, starting with the field key (e.g. config.auth.password).
+	// segments are matched against map keys and struct field names (their json name if tagged) case
+	// insensitively, "*" matches any key, and slices are traversed as if they were their elements. struct
+	// fields tagged with `log:"redact"` are redacted regardless
+	Paths []string
+
+	// replaces the redacted values (default "[redacted]")
+	Replacement string
+}
+
+// RedactingFormatter redacts the fields of entries before another formatter serializes them, so that
+// secrets nested in maps, slices and structs are redacted regardless of how they're serialized. values
+// which contain something to redact are converted to maps and slices, the rest are left as is
+type RedactingFormatter struct {
+	formatter   logrus.Formatter
+	paths       *redactingFormatterPath
+	replacement string
+}
+
+func NewRedactingFormatter(formatter logrus.Formatter, config RedactingFormatterConfig) (*RedactingFormatter, error) {
+	if formatter == nil {
+		return nil, errors.New("formatter is required")
+	}
+
+	if config.Replacement == "" {
+		config.Replacement = redactingFormatterDefaultReplacement
+	}
+
+	paths := &redactingFormatterPath{}
+
+	for _, path := range config.Paths {
+		segments := strings.Split(path, ".")
+
+		for _, segment := range segments {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid redaction path %q", path)
+			}
+		}
+
+		paths.add(segments)
+	}
+
+	paths.mergeWildcards()
+
+	return &RedactingFormatter{
+		formatter:   formatter,
+		paths:       paths,
+		replacement: config.Replacement,
+	}, nil
+}
+
+func (f *RedactingFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	var redactedData logrus.Fields
+
+	for fieldKey, fieldValue := range entry.Data {
+		redactedValue, redacted := f.redactChild(fieldValue, f.paths.getChild(fieldKey), nil, 0)
+		if !redacted {
+			continue
+		}
+
+		// the entry's fields may be shared with others, so they're copied rather than modified
+		if redactedData == nil {
+			redactedData = make(logrus.Fields, len(entry.Data))
+			for originalFieldKey, originalFieldValue := range entry.Data {
+				redactedData[originalFieldKey] = originalFieldValue
+			}
+		}
+
+		redactedData[fieldKey] = redactedValue
+	}
+
+	if redactedData == nil {
+		return f.formatter.Format(entry)
+	}
+
+	redactedEntry := *entry
+	redactedEntry.Data = redactedData
+
+	return f.formatter.Format(&redactedEntry)
+}
+
+// returns the replacement if the child's path (or struct field) is to be redacted, or the child with its
+// own children redacted. returns false if nothing was redacted
+func (f *RedactingFormatter) redactChild(value interface{},
+	path *redactingFormatterPath,
+	field *redactingFormatterField,
+	depth int) (interface{}, bool) {
+
+	if (path != nil && path.redact) || (field != nil && field.redact) {
+		return f.replacement, true
+	}
+
+	return f.redactValue(value, path, depth)
+}
+
+func (f *RedactingFormatter) redactValue(value interface{}, path *redactingFormatterPath, depth int) (interface{}, bool) {
+	if depth > redactingFormatterMaxDepth {
+		return value, false
+	}
+
+	// values which serialize themselves are redacted only as a whole
+	switch value.(type) {
+	case nil, string, []byte, bool, error, json.Marshaler, encoding.TextMarshaler:
+		return value, false
+	}
+
+	reflectValue := reflect.ValueOf(value)
+
+	switch reflectValue.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if reflectValue.IsNil() {
+			return value, false
+		}
+
+		return f.redactValue(reflectValue.Elem().Interface(), path, depth+1)
+
+	case reflect.Map:
+		return f.redactMap(reflectValue, path, depth)
+
+	case reflect.Slice, reflect.Array:
+		return f.redactSlice(reflectValue, path, depth)
+
+	case reflect.Struct:
+		return f.redactStruct(reflectValue, path, depth)
+	}
+
+	return value, false
+}
+
+func (f *RedactingFormatter) redactMap(mapValue reflect.Value,
+	path *redactingFormatterPath,
+	depth int) (interface{}, bool) {
+
+	var redactedMap map[string]interface{}
+
+	mapIterator := mapValue.MapRange()
+	for mapIterator.Next() {
+		key := fmt.Sprint(mapIterator.Key().Interface())
+
+		redactedValue, redacted := f.redactChild(mapIterator.Value().Interface(), path.getChild(key), nil, depth+1)
+		if !redacted {
+			continue
+		}
+
+		if redactedMap == nil {
+			redactedMap = make(map[string]interface{}, mapValue.Len())
+
+			originalMapIterator := mapValue.MapRange()
+			for originalMapIterator.Next() {
+				redactedMap[fmt.Sprint(originalMapIterator.Key().Interface())] = originalMapIterator.Value().Interface()
+			}
+		}
+
+		redactedMap[key] = redactedValue
+	}
+
+	if redactedMap == nil {
+		return mapValue.Interface(), false
+	}
+
+	return redactedMap, true
+}
+
+func (f *RedactingFormatter) redactSlice(sliceValue reflect.Value,
+	path *redactingFormatterPath,
+	depth int) (interface{}, bool) {
+
+	var redactedSlice []interface{}
+
+	for elementIndex := 0; elementIndex < sliceValue.Len(); elementIndex++ {
+		redactedValue, redacted := f.redactValue(sliceValue.Index(elementIndex).Interface(), path, depth+1)
+		if !redacted {
+			continue
+		}
+
+		if redactedSlice == nil {
+			redactedSlice = make([]interface{}, sliceValue.Len())
+			for originalElementIndex := range redactedSlice {
+				redactedSlice[originalElementIndex] = sliceValue.Index(originalElementIndex).Interface()
+			}
+		}
+
+		redactedSlice[elementIndex] = redactedValue
+	}
+
+	if redactedSlice == nil {
+		return sliceValue.Interface(), false
+	}
+
+	return redactedSlice, true
+}
+
+func (f *RedactingFormatter) redactStruct(structValue reflect.Value,
+	path *redactingFormatterPath,
+	depth int) (interface{}, bool) {
+
+	var redactedStruct map[string]interface{}
+	fields := getRedactingFormatterFields(structValue.Type())
+
+	for fieldIndex := range fields {
+		field := &fields[fieldIndex]
+
+		redactedValue, redacted := f.redactChild(structValue.FieldByIndex(field.index).Interface(),
+			path.getChild(field.name),
+			field,
+			depth+1)
+
+		if !redacted {
+			continue
+		}
+
+		if redactedStruct == nil {
+			redactedStruct = make(map[string]interface{}, len(fields))
+
+			for _, originalField := range fields {
+				originalFieldValue := structValue.FieldByIndex(originalField.index)
+				if !originalField.omitEmpty || !originalFieldValue.IsZero() {
+					redactedStruct[originalField.name] = originalFieldValue.Interface()
+				}
+			}
+		}
+
+		redactedStruct[field.name] = redactedValue
+	}
+
+	if redactedStruct == nil {
+		return structValue.Interface(), false
+	}
+
+	return redactedStruct, true
+}
+
+// a node of the redaction paths, by lower case segment
+type redactingFormatterPath struct {
+	redact   bool
+	children map[string]*redactingFormatterPath
+}
+
+func (p *redactingFormatterPath) add(segments []string) {
+	if len(segments) == 0 {
+		p.redact = true
+		return
+	}
+
+	if p.children == nil {
+		p.children = map[string]*redactingFormatterPath{}
+	}
+
+	segment := strings.ToLower(segments[0])
+	if p.children[segment] == nil {
+		p.children[segment] = &redactingFormatterPath{}
+	}
+
+	p.children[segment].add(segments[1:])
+}
+
+// the paths under a wildcard also apply under its siblings
+func (p *redactingFormatterPath) mergeWildcards() {
+	if wildcard, found := p.children[redactingFormatterWildcard]; found {
+		for segment, child := range p.children {
+			if segment != redactingFormatterWildcard {
+				child.merge(wildcard)
+			}
+		}
+	}
+
+	for _, child := range p.children {
+		child.mergeWildcards()
+	}
+}
+
+func (p *redactingFormatterPath) merge(other *redactingFormatterPath) {
+	p.redact = p.redact || other.redact
+
+	for segment, otherChild := range other.children {
+		if p.children == nil {
+			p.children = map[string]*redactingFormatterPath{}
+		}
+
+		if p.children[segment] == nil {
+			p.children[segment] = &redactingFormatterPath{}
+		}
+
+		p.children[segment].merge(otherChild)
+	}
+}
+
+func (p *redactingFormatterPath) getChild(key string) *redactingFormatterPath {
+	if p == nil || p.children == nil {
+		return nil
+	}
+
+	if child, found := p.children[strings.ToLower(key)]; found {
+		return child
+	}
+
+	return p.children[redactingFormatterWildcard]
+}
+
+// an exported field of a struct, as encoding/json would serialize it
+type redactingFormatterField struct {
+	index     []int
+	name      string
+	omitEmpty bool
+	redact    bool
+}
+
+var redactingFormatterFieldsCache sync.Map
+
+func getRedactingFormatterFields(structType reflect.Type) []redactingFormatterField {
+	if cachedFields, found := redactingFormatterFieldsCache.Load(structType); found {
+		return cachedFields.([]redactingFormatterField)
+	}
+
+	fields := appendRedactingFormatterFields(nil, structType, nil)
+	redactingFormatterFieldsCache.Store(structType, fields)
+
+	return fields
+}
+
+func appendRedactingFormatterFields(fields []redactingFormatterField,
+	structType reflect.Type,
+	index []int) []redactingFormatterField {
+
+	for fieldIndex := 0; fieldIndex < structType.NumField(); fieldIndex++ {
+		structField := structType.Field(fieldIndex)
+		jsonTag := structField.Tag.Get("json")
+		jsonName := strings.Split(jsonTag, ",")[0]
+
+		if (structField.PkgPath != "" && !structField.Anonymous) || jsonTag == "-" {
+			continue
+		}
+
+		fieldIndexPath := append(append([]int{}, index...), fieldIndex)
+
+		// untagged embedded structs are flattened, as by encoding/json
+		if structField.Anonymous && jsonName == "" && structField.Type.Kind() == reflect.Struct {
+			fields = appendRedactingFormatterFields(fields, structField.Type, fieldIndexPath)
+			continue
+		}
+
+		if structField.PkgPath != "" {
+			continue
+		}
+
+		if jsonName == "" {
+			jsonName = structField.Name
+		}
+
+		fields = append(fields, redactingFormatterField{
+			index:     fieldIndexPath,
+			name:      jsonName,
+			omitEmpty: strings.Contains(jsonTag, ",omitempty"),
+			redact:    structField.Tag.Get("log") == "redact",
+		})
+	}
+
+	return fields
+}
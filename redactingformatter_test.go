@@ -0,0 +1,152 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type redactingFormatterTestAuth struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Token    string `json:"token,omitempty" log:"redact"`
+}
+
+type redactingFormatterTestConfig struct {
+	Name    string
+	Auth    *redactingFormatterTestAuth `json:"auth"`
+	Hosts   []map[string]interface{}    `json:"hosts"`
+	Created time.Time                   `json:"created"`
+	ignored string
+}
+
+type redactingFormatterSuite struct {
+	suite.Suite
+	output *bytes.Buffer
+}
+
+func (suite *redactingFormatterSuite) SetupTest() {
+	suite.output = new(bytes.Buffer)
+}
+
+func (suite *redactingFormatterSuite) TestPathsAndTags() {
+	loggerInstance := suite.createLogger(RedactingFormatterConfig{
+		Paths: []string{"config.auth.password", "config.hosts.secret", "headers.authorization"},
+	})
+
+	config := redactingFormatterTestConfig{
+		Name: "db",
+		Auth: &redactingFormatterTestAuth{User: "admin", Password: "hunter2", Token: "abc"},
+		Hosts: []map[string]interface{}{
+			{"host": "db-0", "secret": "s0"},
+			{"host": "db-1"},
+		},
+		Created: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+		ignored: "ignored",
+	}
+
+	loggerInstance.InfoWith("Connecting",
+		"config", &config,
+		"headers", map[string]string{"Authorization": "Bearer xyz", "Accept": "*/*"},
+		"count", 3)
+
+	more := suite.getMore()
+
+	suite.Require().JSONEq(`{
+		"Name": "db",
+		"auth": {"user": "admin", "password": "[redacted]", "token": "[redacted]"},
+		"hosts": [{"host": "db-0", "secret": "[redacted]"}, {"host": "db-1"}],
+		"created": "2021-03-01T00:00:00Z"
+	}`, more["config"])
+	suite.Require().JSONEq(`{"Authorization": "[redacted]", "Accept": "*/*"}`, more["headers"])
+	suite.Require().Equal("3", more["count"])
+
+	// the logged values weren't modified
+	suite.Require().Equal("hunter2", config.Auth.Password)
+	suite.Require().Equal("s0", config.Hosts[0]["secret"])
+}
+
+func (suite *redactingFormatterSuite) TestWildcards() {
+	loggerInstance := suite.createLogger(RedactingFormatterConfig{
+		Paths:       []string{"*.password", "db.auth"},
+		Replacement: "***",
+	})
+
+	loggerInstance.InfoWith("Connecting",
+		"db", map[string]interface{}{"password": "p0", "auth": map[string]string{"user": "u"}, "port": 5432},
+		"cache", map[string]interface{}{"password": "p1", "host": "cache-0"},
+		"password", "p2")
+
+	more := suite.getMore()
+
+	suite.Require().JSONEq(`{"password": "***", "auth": "***", "port": 5432}`, more["db"])
+	suite.Require().JSONEq(`{"password": "***", "host": "cache-0"}`, more["cache"])
+	suite.Require().Equal("p2", more["password"])
+}
+
+func (suite *redactingFormatterSuite) TestUnchangedValues() {
+	loggerInstance := suite.createLogger(RedactingFormatterConfig{
+		Paths: []string{"config.auth.password"},
+	})
+
+	loggerInstance.InfoWith("Connecting", "config", redactingFormatterTestConfig{Name: "db"})
+
+	suite.Require().JSONEq(`{
+		"Name": "db",
+		"auth": null,
+		"hosts": null,
+		"created": "0001-01-01T00:00:00Z"
+	}`, suite.getMore()["config"])
+}
+
+func (suite *redactingFormatterSuite) TestInvalidConfiguration() {
+	_, err := NewRedactingFormatter(nil, RedactingFormatterConfig{})
+	suite.Require().Error(err)
+
+	_, err = NewRedactingFormatter(&JSONFormatter{}, RedactingFormatterConfig{Paths: []string{"config..password"}})
+	suite.Require().Error(err)
+}
+
+func (suite *redactingFormatterSuite) createLogger(config RedactingFormatterConfig) *Loggerus {
+	redactingFormatter, err := NewRedactingFormatter(&JSONFormatter{}, config)
+	suite.Require().NoError(err)
+
+	loggerInstance, err := NewLoggerus("test", logrus.DebugLevel, suite.output, redactingFormatter)
+	suite.Require().NoError(err)
+
+	return loggerInstance
+}
+
+func (suite *redactingFormatterSuite) getMore() map[string]string {
+	entry := struct {
+		More map[string]string `json:"more"`
+	}{}
+
+	suite.Require().NoError(json.Unmarshal(suite.output.Bytes(), &entry))
+
+	return entry.More
+}
+
+func TestRedactingFormatterTestSuite(t *testing.T) {
+	suite.Run(t, new(redactingFormatterSuite))
+}
@@ -20,7 +20,9 @@ import (
 	"fmt"
 	"io"
 	"regexp"
-	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type RedactingLogger interface {
@@ -29,39 +31,154 @@ type RedactingLogger interface {
 }
 
 type Redactor struct {
-	disabled               bool
+	disabled               int32
 	output                 io.Writer
-	redactions             []string
-	valueRedactions        []string
+	redactions             []redactorRuleSpec
+	valueRedactions        []redactorRuleSpec
+	detectors              []redactorRuleSpec
+	regexRedactions        []redactorRuleSpec
 	replacementString      string
 	valueReplacementString string
+
+	// serializes rule updates, which compile a new snapshot of the rules and swap it in. writes only load
+	// the current snapshot, so they're never blocked by updates
+	lock  sync.Mutex
+	rules atomic.Value
+
+	// a *redactorLineBuffer, nil unless line buffering
+	lineBuffer atomic.Value
+
+	metricsHook          RedactorMetricsHook
+	ruleStats            map[string]*redactorRuleStats
+	statsSummaryStopChan chan struct{}
+	statsSummaryStopped  chan struct{}
+
+	// the rules of the rules file (see LoadRulesFile), in addition to those added
+	fileRedactions      []redactorRuleSpec
+	fileValueRedactions []redactorRuleSpec
+	fileRegexRedactions []redactorRuleSpec
+	rulesFileStopChan   chan struct{}
+	rulesFileStopped    chan struct{}
+
+	nextHandle RedactorHandle
+
+	// fires when the first of the rules with a TTL expires, unless closed
+	expiryTimer *time.Timer
+	closed      bool
+}
+
+type RedactorRuleOptions struct {
+
+	// reported in the statistics of the rules, which are kept by name (defaults to "redactions" for redactions, the
+	// key for value redactions, the detector for detectors and "regex-redactions" for regex redactions). must not be
+	// the secret itself
+	Name string
+
+	// replaces what the rule matched (default "*****" for redactions, "[redacted]" for value redactions and
+	// "[redacted:<detector>]" for detectors)
+	Mask RedactorMask
+
+	// the rules are removed once they've been added for this long, e.g. for short-lived tokens (0 never expires)
+	TTL time.Duration
+
+	// redactions are also matched in these encodings (ignored by other rules)
+	Encodings RedactorEncoding
+}
+
+// identifies the rules added together, with which they can be removed
+type RedactorHandle uint64
+
+// a rule as added - a redaction, value redaction, detector or regex redaction, and its options
+type redactorRuleSpec struct {
+	value   string
+	options RedactorRuleOptions
+
+	// of the call which added the rule, or last replaced its options (0 if it wasn't added with a handle)
+	handle RedactorHandle
+
+	// zero if the rule doesn't expire
+	expiryTime time.Time
+
+	// of regex redactions, compiled once they're validated
+	regex *regexp.Regexp
 }
 
 func NewRedactor(output io.Writer) *Redactor {
-	return &Redactor{
+	newRedactor := Redactor{
 		output:                 output,
-		redactions:             []string{},
-		valueRedactions:        []string{},
 		replacementString:      "*****",
 		valueReplacementString: "[redacted]",
-		disabled:               false,
+		ruleStats:              map[string]*redactorRuleStats{},
 	}
+
+	newRedactor.compileRules() // nolint: errcheck
+	newRedactor.lineBuffer.Store((*redactorLineBuffer)(nil))
+
+	return &newRedactor
 }
 
 func (r *Redactor) GetOutput() io.Writer {
 	return r.output
 }
 
+// AddValueRedactions adds value redactions. if they can't be compiled (e.g. too many), none are added - use
+// AddValueRedactionsWithOptions to get the error
 func (r *Redactor) AddValueRedactions(valueRedactions []string) {
-	r.valueRedactions = append(r.valueRedactions, valueRedactions...)
-	r.valueRedactions = r.removeDuplicates(r.valueRedactions)
+	r.AddValueRedactionsWithOptions(valueRedactions, RedactorRuleOptions{}) // nolint: errcheck
+}
+
+// AddValueRedactionsWithOptions adds value redactions, or replaces the options of existing ones, returning a
+// handle with which they can be removed (0 if there are none). if the value redactions can't be compiled (e.g.
+// too many), none are added
+func (r *Redactor) AddValueRedactionsWithOptions(valueRedactions []string,
+	options RedactorRuleOptions) (RedactorHandle, error) {
+
+	if len(valueRedactions) == 0 {
+		return 0, nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	previousValueRedactions := r.valueRedactions
+	r.valueRedactions = addRedactorRuleSpecs(r.valueRedactions, valueRedactions, options, r.nextHandle+1)
+
+	if err := r.compileRules(); err != nil {
+		r.valueRedactions = previousValueRedactions
+		return 0, err
+	}
+
+	r.nextHandle++
+
+	return r.nextHandle, nil
+}
+
+// RemoveValueRedactions removes value redactions (those of the rules file aren't removed)
+func (r *Redactor) RemoveValueRedactions(valueRedactions []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.valueRedactions, _ = removeRedactorRuleSpecs(r.valueRedactions, func(spec *redactorRuleSpec) bool {
+		return containsString(valueRedactions, spec.value)
+	})
+
+	r.compileRules() // nolint: errcheck
 }
 
 func (r *Redactor) GetRedactions() []string {
-	return r.redactions
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return getRedactorRuleSpecValues(r.redactions)
 }
 
 func (r *Redactor) AddRedactions(redactions []string) {
+	r.AddRedactionsWithOptions(redactions, RedactorRuleOptions{})
+}
+
+// AddRedactionsWithOptions adds redactions, or replaces the options of existing ones, returning a handle with
+// which they can be removed (0 if there are none)
+func (r *Redactor) AddRedactionsWithOptions(redactions []string, options RedactorRuleOptions) RedactorHandle {
 	var nonEmptyRedactions []string
 
 	for _, redaction := range redactions {
@@ -70,73 +187,471 @@ func (r *Redactor) AddRedactions(redactions []string) {
 		}
 	}
 
-	r.redactions = append(r.redactions, nonEmptyRedactions...)
-	r.redactions = r.removeDuplicates(r.redactions)
+	if len(nonEmptyRedactions) == 0 {
+		return 0
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.nextHandle++
+	r.redactions = addRedactorRuleSpecs(r.redactions, nonEmptyRedactions, options, r.nextHandle)
+	r.compileRules() // nolint: errcheck
+
+	return r.nextHandle
+}
+
+// RemoveRedactions removes redactions (those of the rules file aren't removed)
+func (r *Redactor) RemoveRedactions(redactions []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.redactions, _ = removeRedactorRuleSpecs(r.redactions, func(spec *redactorRuleSpec) bool {
+		return containsString(redactions, spec.value)
+	})
+
+	r.compileRules() // nolint: errcheck
+}
+
+func (r *Redactor) AddRegexRedactions(regexRedactions []string) error {
+	_, err := r.AddRegexRedactionsWithOptions(regexRedactions, RedactorRuleOptions{})
+	return err
+}
+
+// AddRegexRedactionsWithOptions adds regular expressions whose matches are redacted - only their group named
+// "secret" if they have one (e.g. postgres://[^:/]+:(?P<secret>[^@]+)@), otherwise the whole match - or replaces
+// the options of existing ones, returning a handle with which they can be removed (0 if there are none). if
+// any is invalid, none are added
+func (r *Redactor) AddRegexRedactionsWithOptions(regexRedactions []string,
+	options RedactorRuleOptions) (RedactorHandle, error) {
+
+	if len(regexRedactions) == 0 {
+		return 0, nil
+	}
+
+	regexes := map[string]*regexp.Regexp{}
+
+	for _, regexRedaction := range regexRedactions {
+		regex, err := compileRedactorRegex(regexRedaction)
+		if err != nil {
+			return 0, err
+		}
+
+		regexes[regexRedaction] = regex
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.nextHandle++
+	r.regexRedactions = addRedactorRuleSpecs(r.regexRedactions, regexRedactions, options, r.nextHandle)
+
+	for specIndex := range r.regexRedactions {
+		if regex, found := regexes[r.regexRedactions[specIndex].value]; found {
+			r.regexRedactions[specIndex].regex = regex
+		}
+	}
+
+	r.compileRules() // nolint: errcheck
+
+	return r.nextHandle, nil
+}
+
+// RemoveRegexRedactions removes regex redactions (those of the rules file aren't removed)
+func (r *Redactor) RemoveRegexRedactions(regexRedactions []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.regexRedactions, _ = removeRedactorRuleSpecs(r.regexRedactions, func(spec *redactorRuleSpec) bool {
+		return containsString(regexRedactions, spec.value)
+	})
+
+	r.compileRules() // nolint: errcheck
+}
+
+func (r *Redactor) GetRegexRedactions() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return getRedactorRuleSpecValues(r.regexRedactions)
+}
+
+// RemoveRules removes the redactions, value redactions and regex redactions added with a handle (unless they've
+// been added again since, with another handle)
+func (r *Redactor) RemoveRules(handle RedactorHandle) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	hasHandle := func(spec *redactorRuleSpec) bool {
+		return spec.handle == handle
+	}
+
+	redactions, redactionsRemoved := removeRedactorRuleSpecs(r.redactions, hasHandle)
+	valueRedactions, valueRedactionsRemoved := removeRedactorRuleSpecs(r.valueRedactions, hasHandle)
+	regexRedactions, regexRedactionsRemoved := removeRedactorRuleSpecs(r.regexRedactions, hasHandle)
+
+	if !redactionsRemoved && !valueRedactionsRemoved && !regexRedactionsRemoved {
+		return fmt.Errorf("no redaction rules with handle %d", handle)
+	}
+
+	r.redactions = redactions
+	r.valueRedactions = valueRedactions
+	r.regexRedactions = regexRedactions
+	r.compileRules() // nolint: errcheck
+
+	return nil
+}
+
+// EnableDetectors enables built-in detectors of secrets, whose detections are replaced with
+// [redacted:<detector>]
+func (r *Redactor) EnableDetectors(detectors ...RedactorDetector) error {
+	return r.EnableDetectorsWithOptions(detectors, RedactorRuleOptions{})
+}
+
+// EnableDetectorsWithOptions enables built-in detectors of secrets, or replaces the options of enabled ones
+func (r *Redactor) EnableDetectorsWithOptions(detectors []RedactorDetector, options RedactorRuleOptions) error {
+	var detectorNames []string
+
+	for _, detector := range detectors {
+		if _, found := redactorDetectors[detector]; !found {
+			return fmt.Errorf("unknown redactor detector %q", detector)
+		}
+
+		detectorNames = append(detectorNames, string(detector))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.detectors = addRedactorRuleSpecs(r.detectors, detectorNames, options, 0)
+	r.compileRules() // nolint: errcheck
+
+	return nil
+}
+
+// DisableDetectors disables built-in detectors of secrets
+func (r *Redactor) DisableDetectors(detectors ...RedactorDetector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var enabledDetectors []redactorRuleSpec
+	for _, detector := range r.detectors {
+		if !containsRedactorDetector(detectors, RedactorDetector(detector.value)) {
+			enabledDetectors = append(enabledDetectors, detector)
+		}
+	}
+
+	r.detectors = enabledDetectors
+	r.compileRules() // nolint: errcheck
+}
+
+// GetDetectors returns the enabled built-in detectors
+func (r *Redactor) GetDetectors() []RedactorDetector {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var detectors []RedactorDetector
+	for _, detector := range r.detectors {
+		detectors = append(detectors, RedactorDetector(detector.value))
+	}
+
+	return detectors
 }
 
 func (r *Redactor) Write(p []byte) (n int, err error) {
-	redactedPrint := r.redact(string(p))
-	n, err = r.output.Write([]byte(redactedPrint))
-	if err != nil {
-		return
+	if lineBuffer := r.lineBuffer.Load().(*redactorLineBuffer); lineBuffer != nil {
+		return len(p), lineBuffer.write(p)
 	}
-	if n != len(redactedPrint) {
-		err = io.ErrShortWrite
-		return
+
+	if err := r.writeRedacted(p); err != nil {
+		return 0, err
 	}
 
 	// HACK: let the caller know we wrote the original length of the text
 	// To prevent caller explode while validating the length of the written text (redaction might change the length)
-	return len(p), err
+	return len(p), nil
+}
+
+// EnableLineBuffering holds incomplete lines until they're complete, so that secrets split across writes
+// are redacted too
+func (r *Redactor) EnableLineBuffering(config RedactorLineBufferingConfig) error {
+	lineBuffer, err := newRedactorLineBuffer(r, config)
+	if err != nil {
+		return err
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	previousLineBuffer := r.lineBuffer.Load().(*redactorLineBuffer)
+	r.lineBuffer.Store(lineBuffer)
+
+	if previousLineBuffer != nil {
+		return previousLineBuffer.flush()
+	}
+
+	return nil
+}
+
+// DisableLineBuffering writes the held incomplete line (if any), and stops holding them
+func (r *Redactor) DisableLineBuffering() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	previousLineBuffer := r.lineBuffer.Load().(*redactorLineBuffer)
+	r.lineBuffer.Store((*redactorLineBuffer)(nil))
+
+	if previousLineBuffer != nil {
+		return previousLineBuffer.flush()
+	}
+
+	return nil
+}
+
+// Flush writes the held incomplete line (if any) and flushes the output, if applicable
+func (r *Redactor) Flush() error {
+	if lineBuffer := r.lineBuffer.Load().(*redactorLineBuffer); lineBuffer != nil {
+		if err := lineBuffer.flush(); err != nil {
+			return err
+		}
+	}
+
+	if outputFlusher, ok := r.output.(flusher); ok {
+		return outputFlusher.Flush()
+	}
+
+	return nil
+}
+
+// Close writes the held incomplete line (if any), stops the stats summary, watching the rules file and expiring
+// rules, and closes the output, if applicable
+func (r *Redactor) Close() error {
+	r.DisableStatsSummary()
+	r.StopWatchingRulesFile()
+	r.stopExpiringRules()
+
+	return newMultiError([]error{r.DisableLineBuffering(), closeOutput(r.output)})
 }
 
 func (r *Redactor) Enable() {
-	r.disabled = false
+	atomic.StoreInt32(&r.disabled, 0)
 }
 
 func (r *Redactor) Disable() {
-	r.disabled = true
+	atomic.StoreInt32(&r.disabled, 1)
 }
 
-func (r *Redactor) redact(input string) string {
-	if r.disabled {
+func (r *Redactor) writeRedacted(p []byte) error {
+	redactedPrint := r.redact(p)
+
+	n, err := r.output.Write(redactedPrint)
+	if err != nil {
+		return err
+	}
+
+	if n != len(redactedPrint) {
+		return io.ErrShortWrite
+	}
+
+	return nil
+}
+
+func (r *Redactor) redact(input []byte) []byte {
+	if atomic.LoadInt32(&r.disabled) != 0 {
 		return input
 	}
 
-	redacted := input
+	return r.getRules().redact(input)
+}
+
+func (r *Redactor) getRules() *redactorRules {
+	return r.rules.Load().(*redactorRules)
+}
+
+// must be called with the lock held (or before the redactor is shared). on error, the current rules are kept.
+// only added value redactions may fail to compile (rules which compiled still do once some are removed, or
+// their options change), so the callers which don't add them ignore errors
+func (r *Redactor) compileRules() error {
+	previousRules, _ := r.rules.Load().(*redactorRules)
+
+	rules, err := compileRedactorRules(&redactorRulesSpec{
+		redactions:             mergeRedactorRuleSpecs(r.redactions, r.fileRedactions),
+		valueRedactions:        mergeRedactorRuleSpecs(r.valueRedactions, r.fileValueRedactions),
+		detectors:              r.detectors,
+		regexRedactions:        mergeRedactorRuleSpecs(r.regexRedactions, r.fileRegexRedactions),
+		replacementString:      r.replacementString,
+		valueReplacementString: r.valueReplacementString,
+		metricsHook:            r.metricsHook,
+		ruleStats:              r.ruleStats,
+		previousRules:          previousRules,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	r.rules.Store(rules)
+	r.scheduleExpiry()
+
+	return nil
+}
+
+// must be called with the lock held
+func (r *Redactor) scheduleExpiry() {
+	var firstExpiryTime time.Time
+
+	for _, specs := range [][]redactorRuleSpec{r.redactions, r.valueRedactions, r.detectors, r.regexRedactions} {
+		for _, spec := range specs {
+			if !spec.expiryTime.IsZero() && (firstExpiryTime.IsZero() || spec.expiryTime.Before(firstExpiryTime)) {
+				firstExpiryTime = spec.expiryTime
+			}
+		}
+	}
+
+	if r.expiryTimer != nil {
+		r.expiryTimer.Stop()
+		r.expiryTimer = nil
+	}
+
+	if !firstExpiryTime.IsZero() && !r.closed {
+		r.expiryTimer = time.AfterFunc(time.Until(firstExpiryTime), r.removeExpiredRules)
+	}
+}
+
+func (r *Redactor) stopExpiringRules() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.closed = true
+
+	if r.expiryTimer != nil {
+		r.expiryTimer.Stop()
+		r.expiryTimer = nil
+	}
+}
+
+// removes all the rules which expired, in a single compilation
+func (r *Redactor) removeExpiredRules() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// the timer fired as the redactor was closed
+	if r.closed {
+		return
+	}
+
+	now := time.Now()
+	hasExpired := func(spec *redactorRuleSpec) bool {
+		return !spec.expiryTime.IsZero() && !spec.expiryTime.After(now)
+	}
+
+	var redactionsRemoved, valueRedactionsRemoved, detectorsRemoved, regexRedactionsRemoved bool
+	r.redactions, redactionsRemoved = removeRedactorRuleSpecs(r.redactions, hasExpired)
+	r.valueRedactions, valueRedactionsRemoved = removeRedactorRuleSpecs(r.valueRedactions, hasExpired)
+	r.detectors, detectorsRemoved = removeRedactorRuleSpecs(r.detectors, hasExpired)
+	r.regexRedactions, regexRedactionsRemoved = removeRedactorRuleSpecs(r.regexRedactions, hasExpired)
+
+	// otherwise, a timer stopped too late fired, and the scheduled one is still valid
+	if redactionsRemoved || valueRedactionsRemoved || detectorsRemoved || regexRedactionsRemoved {
+		r.compileRules() // nolint: errcheck
+	}
+}
+
+// returns a copy of the specs with those of the values added, or their options replaced if they exist
+func addRedactorRuleSpecs(specs []redactorRuleSpec,
+	values []string,
+	options RedactorRuleOptions,
+	handle RedactorHandle) []redactorRuleSpec {
+
+	var expiryTime time.Time
+	if options.TTL > 0 {
+		expiryTime = time.Now().Add(options.TTL)
+	}
+
+	specs = append([]redactorRuleSpec{}, specs...)
 
-	// golang regex doesn't support lookarounds, so we will check things manually
-	matchKeyWithSeparatorTemplate := `\\*[\'"]?(?i)%s\\*[\'"]?\s*[=:]\s*`
-	matchValue := `\'[^\']*?\'|\"[^\"]*\"|\S*`
+	for _, value := range values {
+		found := false
 
-	// redact values of either strings of type `valueRedaction=[value]` or `valueRedaction: [value]`
-	// w/wo single/double quotes
-	for _, redactionField := range r.valueRedactions {
-		matchKeyWithSeparator := fmt.Sprintf(matchKeyWithSeparatorTemplate, redactionField)
-		re := regexp.MustCompile(fmt.Sprintf(`(%s)(%s)`, matchKeyWithSeparator, matchValue))
-		redacted = re.ReplaceAllString(redacted, fmt.Sprintf(`$1%s`, r.valueReplacementString))
+		for specIndex := range specs {
+			if specs[specIndex].value == value {
+				specs[specIndex].options = options
+				specs[specIndex].handle = handle
+				specs[specIndex].expiryTime = expiryTime
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			specs = append(specs, redactorRuleSpec{
+				value:      value,
+				options:    options,
+				handle:     handle,
+				expiryTime: expiryTime,
+			})
+		}
 	}
 
-	// replace the simple string redactions
-	for _, redactionField := range r.redactions {
-		redacted = strings.ReplaceAll(redacted, redactionField, r.replacementString)
+	return specs
+}
+
+// returns a copy of the specs without those to remove, and whether any were removed
+func removeRedactorRuleSpecs(specs []redactorRuleSpec,
+	shouldRemove func(spec *redactorRuleSpec) bool) ([]redactorRuleSpec, bool) {
+
+	var keptSpecs []redactorRuleSpec
+
+	for specIndex := range specs {
+		if !shouldRemove(&specs[specIndex]) {
+			keptSpecs = append(keptSpecs, specs[specIndex])
+		}
 	}
 
-	return redacted
+	return keptSpecs, len(keptSpecs) != len(specs)
 }
 
-func (r *Redactor) removeDuplicates(elements []string) []string {
-	encountered := map[string]bool{}
+// returns the specs followed by the other specs whose values aren't among them
+func mergeRedactorRuleSpecs(specs []redactorRuleSpec, otherSpecs []redactorRuleSpec) []redactorRuleSpec {
+	if len(otherSpecs) == 0 {
+		return specs
+	}
+
+	mergedSpecs := append([]redactorRuleSpec{}, specs...)
 
-	// Create a map of all unique elements.
-	for v := range elements {
-		encountered[elements[v]] = true
+	for _, otherSpec := range otherSpecs {
+		found := false
+
+		for _, spec := range specs {
+			if spec.value == otherSpec.value {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			mergedSpecs = append(mergedSpecs, otherSpec)
+		}
+	}
+
+	return mergedSpecs
+}
+
+func getRedactorRuleSpecValues(specs []redactorRuleSpec) []string {
+	values := []string{}
+	for _, spec := range specs {
+		values = append(values, spec.value)
 	}
 
-	// Place all keys from the map into a slice.
-	var result []string
-	for key := range encountered {
-		result = append(result, key)
+	return values
+}
+
+func containsRedactorDetector(detectors []RedactorDetector, detector RedactorDetector) bool {
+	for _, candidateDetector := range detectors {
+		if candidateDetector == detector {
+			return true
+		}
 	}
-	return result
+
+	return false
 }
@@ -18,9 +18,13 @@ package loggerus
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -94,6 +98,316 @@ func (suite *redactorSuite) TestRegularRedactions() {
 	suite.Assert().True(strings.Contains(redactedCommand, "{asdhaksjd:\\ ***** \\ \n}"))
 }
 
+func (suite *redactorSuite) TestOverlappingRedactions() {
+	buf := new(bytes.Buffer)
+
+	suite.redactor = NewRedactor(buf)
+	suite.redactor.AddRedactions([]string{"secret", "cretin", "token"})
+	suite.redactor.AddValueRedactions([]string{"password"})
+
+	_, err := suite.redactor.Write([]byte("secretin tokentoken password=secret and secretly"))
+	suite.Require().NoError(err)
+
+	// overlapping redactions are replaced once, and the value redaction wins over the literal in it
+	suite.Require().Equal("***** ********** password=[redacted] and *****ly", buf.String())
+}
+
+func (suite *redactorSuite) TestValueRedactionKeys() {
+	for _, testCase := range []struct {
+		valueRedactions []string
+		input           string
+		expected        string
+	}{
+		{[]string{"password"}, "PassWord: 'a b' x", "PassWord: [redacted] x"},
+		{[]string{"pass", "password"}, "password=a passport=b", "password=[redacted] passport=b"},
+		{[]string{"token"}, "to\u212aen=a token=b", "to\u212aen=[redacted] token=[redacted]"},
+		{[]string{"pass(word)?"}, "pass=a password=b", "pass=[redacted] password=[redacted]"},
+		{[]string{"key["}, "key[=a key=b", "key[=[redacted] key=b"},
+	} {
+		buf := new(bytes.Buffer)
+		suite.redactor = NewRedactor(buf)
+		suite.redactor.AddValueRedactions(testCase.valueRedactions)
+
+		_, err := suite.redactor.Write([]byte(testCase.input))
+		suite.Require().NoError(err)
+		suite.Require().Equal(testCase.expected, buf.String())
+	}
+}
+
+func (suite *redactorSuite) TestMatchesLegacyRedaction() {
+	for _, testFile := range []string{"test/key_value.txt", "test/dict.txt"} {
+		unredactedCommand, err := ioutil.ReadFile(testFile)
+		suite.Require().NoError(err)
+
+		buf := new(bytes.Buffer)
+		suite.redactor = NewRedactor(buf)
+		suite.redactor.AddValueRedactions(redactorBenchmarkValueRedactions)
+		suite.redactor.AddRedactions(redactorBenchmarkRedactions)
+
+		_, err = suite.redactor.Write(unredactedCommand)
+		suite.Require().NoError(err)
+
+		suite.Require().Equal(legacyRedact(suite.redactor, string(unredactedCommand)), buf.String(), testFile)
+	}
+}
+
+func (suite *redactorSuite) TestNoMatchDoesNotAllocate() {
+	suite.redactor = NewRedactor(ioutil.Discard)
+	suite.redactor.AddValueRedactions(redactorBenchmarkValueRedactions)
+	suite.redactor.AddRedactions(redactorBenchmarkRedactions)
+
+	input := []byte(redactorBenchmarkNoMatchInput)
+	allocations := testing.AllocsPerRun(100, func() {
+		suite.redactor.Write(input) // nolint: errcheck
+	})
+
+	suite.Require().Zero(allocations)
+}
+
+func (suite *redactorSuite) TestConcurrentUpdates() {
+	output := &testOutput{}
+	suite.redactor = NewRedactor(output)
+
+	var waitGroup sync.WaitGroup
+
+	for writerIndex := 0; writerIndex < 4; writerIndex++ {
+		waitGroup.Add(1)
+
+		go func(writerIndex int) {
+			defer waitGroup.Done()
+
+			for entryIndex := 0; entryIndex < 200; entryIndex++ {
+				suite.redactor.Write([]byte(fmt.Sprintf("secret-%d password=%d\n", writerIndex, entryIndex))) // nolint: errcheck
+			}
+		}(writerIndex)
+	}
+
+	for secretIndex := 0; secretIndex < 50; secretIndex++ {
+		suite.redactor.AddRedactions([]string{fmt.Sprintf("secret-%d", secretIndex)})
+		suite.redactor.AddValueRedactions([]string{"password"})
+		suite.redactor.Disable()
+		suite.redactor.Enable()
+		suite.Require().Len(suite.redactor.GetRedactions(), secretIndex+1)
+	}
+
+	waitGroup.Wait()
+
+	// once registered, secrets are redacted
+	suite.redactor.Write([]byte("secret-3 password=1\n")) // nolint: errcheck
+	suite.Require().True(strings.HasSuffix(output.buffer.String(), "\n***** password=[redacted]\n"))
+}
+
+func (suite *redactorSuite) TestUncompilableValueRedactions() {
+	suite.redactor = NewRedactor(ioutil.Discard)
+	suite.redactor.AddValueRedactions([]string{"password"})
+
+	// valid on its own, but too deeply nested once combined with the rest
+	deeplyNestedKey := strings.Repeat("(", 998) + "key" + strings.Repeat(")", 998)
+
+	_, err := suite.redactor.AddValueRedactionsWithOptions([]string{"token", deeplyNestedKey}, RedactorRuleOptions{})
+	suite.Require().Error(err)
+
+	// ignored by AddValueRedactions
+	suite.redactor.AddValueRedactions([]string{"token", deeplyNestedKey})
+
+	// the previous rules are kept
+	suite.Require().Equal("password=[redacted] token=1",
+		string(suite.redactor.redact([]byte("password=1 token=1"))))
+
+	suite.redactor.AddValueRedactions([]string{"token"})
+	suite.Require().Equal("password=[redacted] token=[redacted]",
+		string(suite.redactor.redact([]byte("password=1 token=1"))))
+}
+
+func (suite *redactorSuite) TestRemoveRedactions() {
+	suite.redactor = NewRedactor(ioutil.Discard)
+	suite.redactor.AddRedactions([]string{"secret-a", "secret-b"})
+	suite.redactor.AddValueRedactions([]string{"password", "token"})
+	handle := suite.redactor.AddRedactionsWithOptions([]string{"secret-c", "secret-d"}, RedactorRuleOptions{})
+	valueHandle, err := suite.redactor.AddValueRedactionsWithOptions([]string{"session"}, RedactorRuleOptions{})
+	suite.Require().NoError(err)
+
+	// nothing to add, so no handle
+	suite.Require().Zero(suite.redactor.AddRedactionsWithOptions([]string{""}, RedactorRuleOptions{}))
+
+	suite.redactor.RemoveRedactions([]string{"secret-a", "missing"})
+	suite.redactor.RemoveValueRedactions([]string{"token"})
+	suite.Require().Equal([]string{"secret-b", "secret-c", "secret-d"}, suite.redactor.GetRedactions())
+
+	// rules added again since are kept
+	suite.redactor.AddRedactions([]string{"secret-d"})
+	suite.Require().NoError(suite.redactor.RemoveRules(handle))
+	suite.Require().NoError(suite.redactor.RemoveRules(valueHandle))
+	suite.Require().Error(suite.redactor.RemoveRules(handle))
+
+	suite.Require().Equal("secret-a ***** secret-c ***** password=[redacted] token=1 session=2",
+		string(suite.redactor.redact([]byte("secret-a secret-b secret-c secret-d password=1 token=1 session=2"))))
+}
+
+func (suite *redactorSuite) TestExpiringRedactions() {
+	suite.redactor = NewRedactor(ioutil.Discard)
+	suite.redactor.AddRedactions([]string{"long-lived"})
+	suite.redactor.AddRedactionsWithOptions([]string{"short-lived"}, RedactorRuleOptions{TTL: 20 * time.Millisecond})
+	suite.redactor.AddValueRedactionsWithOptions([]string{"token"}, RedactorRuleOptions{TTL: 40 * time.Millisecond})
+
+	suite.Require().Equal("***** ***** token=[redacted]",
+		string(suite.redactor.redact([]byte("long-lived short-lived token=1"))))
+
+	suite.Require().Eventually(func() bool {
+		return len(suite.redactor.GetRedactions()) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	suite.Require().Eventually(func() bool {
+		return string(suite.redactor.redact([]byte("long-lived short-lived token=1"))) == "***** short-lived token=1"
+	}, time.Second, 5*time.Millisecond)
+
+	// adding a rule again renews it
+	suite.redactor.AddRedactionsWithOptions([]string{"renewed"}, RedactorRuleOptions{TTL: 20 * time.Millisecond})
+	suite.redactor.AddRedactionsWithOptions([]string{"renewed"}, RedactorRuleOptions{})
+
+	time.Sleep(40 * time.Millisecond)
+	suite.Require().Equal([]string{"long-lived", "renewed"}, suite.redactor.GetRedactions())
+
+	// once closed, rules no longer expire
+	suite.redactor.AddRedactionsWithOptions([]string{"expiring"}, RedactorRuleOptions{TTL: 20 * time.Millisecond})
+	suite.Require().NoError(suite.redactor.Close())
+
+	time.Sleep(40 * time.Millisecond)
+	suite.Require().Equal([]string{"long-lived", "renewed", "expiring"}, suite.redactor.GetRedactions())
+}
+
+func (suite *redactorSuite) TestRegexRedactions() {
+	suite.redactor = NewRedactor(ioutil.Discard)
+
+	suite.Require().NoError(suite.redactor.AddRegexRedactions([]string{
+		`postgres://[^:/]+:(?P<secret>[^@]+)@`,
+		`sk_live_[0-9a-zA-Z]{8}`,
+		`user=\w+( pass=(?P<secret>\w+))?`,
+	}))
+
+	suite.Require().Equal("postgres://admin:*****@db:5432 key=***** user=a user=b pass=*****",
+		string(suite.redactor.redact([]byte("postgres://admin:hunter2@db:5432 key=sk_live_abcd1234 user=a user=b pass=c"))))
+
+	// invalid regexes are rejected, and none of those registered with them are added
+	for _, invalidRegexRedaction := range []string{
+		`sk_live_[0-9`,
+		`(?P<secret>a)(?P<secret>b)`,
+		`[0-9]*`,
+		``,
+	} {
+		_, err := suite.redactor.AddRegexRedactionsWithOptions([]string{"valid", invalidRegexRedaction},
+			RedactorRuleOptions{})
+
+		suite.Require().Error(err, invalidRegexRedaction)
+	}
+
+	suite.Require().Len(suite.redactor.GetRegexRedactions(), 3)
+
+	handle, err := suite.redactor.AddRegexRedactionsWithOptions([]string{`token-\d+`}, RedactorRuleOptions{
+		Mask: NewRedactorFixedLengthMask(3),
+	})
+	suite.Require().NoError(err)
+	suite.Require().Equal("***", string(suite.redactor.redact([]byte("token-123"))))
+
+	suite.Require().NoError(suite.redactor.RemoveRules(handle))
+	suite.redactor.RemoveRegexRedactions([]string{`sk_live_[0-9a-zA-Z]{8}`})
+	suite.Require().Equal("token-123 sk_live_abcd1234", string(suite.redactor.redact([]byte("token-123 sk_live_abcd1234"))))
+}
+
+func (suite *redactorSuite) TestMatchersReusedWhileUnchanged() {
+	suite.redactor = NewRedactor(ioutil.Discard)
+	suite.redactor.AddRedactions([]string{"secret"})
+	suite.redactor.AddValueRedactions([]string{"password"})
+
+	rules := suite.redactor.getRules()
+
+	// only the options changed
+	suite.redactor.AddRedactionsWithOptions([]string{"secret"}, RedactorRuleOptions{Name: "secrets"})
+	suite.Require().Same(rules.literalMatcher, suite.redactor.getRules().literalMatcher)
+	suite.Require().Same(rules.valueMatcher, suite.redactor.getRules().valueMatcher)
+
+	suite.redactor.RemoveRedactions([]string{"secret"})
+	suite.redactor.AddRedactions([]string{"other-secret"})
+	suite.Require().NotSame(rules.literalMatcher, suite.redactor.getRules().literalMatcher)
+	suite.Require().Same(rules.valueMatcher, suite.redactor.getRules().valueMatcher)
+}
+
 func TestRedactorTestSuite(t *testing.T) {
 	suite.Run(t, new(redactorSuite))
 }
+
+var (
+	redactorBenchmarkValueRedactions = []string{
+		"password", "secret", "token", "artifactVersionManifestContents", "systemConfigContents",
+	}
+	redactorBenchmarkRedactions = redactorBenchmarkLiterals(50)
+
+	redactorBenchmarkNoMatchInput = `{"level":"info","time":"2021-03-01T10:00:00.000Z","name":"controller",` +
+		`"message":"Created app service batch","more":{"stage":1,"kind":"nuclio","enabled":true,` +
+		`"resources":{"limits":{},"requests":{}},"min_replicas":1,"max_replicas":4}}` + "\n"
+	redactorBenchmarkMatchInput = `{"level":"info","time":"2021-03-01T10:00:00.000Z","name":"controller",` +
+		`"message":"Created app service batch","more":{"stage":1,"password":"hunter2",` +
+		`"key":"literal-secret-17","min_replicas":1,"max_replicas":4}}` + "\n"
+)
+
+func redactorBenchmarkLiterals(count int) []string {
+	var literals []string
+	for literalIndex := 0; literalIndex < count; literalIndex++ {
+		literals = append(literals, fmt.Sprintf("literal-secret-%d", literalIndex))
+	}
+
+	return literals
+}
+
+// the redaction before rules were precompiled, for comparison
+func legacyRedact(r *Redactor, input string) string {
+	redacted := input
+
+	for _, redactionField := range r.valueRedactions {
+		matchKeyWithSeparator := fmt.Sprintf(`\\*[\'"]?(?i)%s\\*[\'"]?\s*[=:]\s*`, redactionField.value)
+		re := regexp.MustCompile(fmt.Sprintf(`(%s)(%s)`, matchKeyWithSeparator, redactorMatchValue))
+		redacted = re.ReplaceAllString(redacted, fmt.Sprintf(`$1%s`, r.valueReplacementString))
+	}
+
+	for _, redactionField := range r.redactions {
+		redacted = strings.ReplaceAll(redacted, redactionField.value, r.replacementString)
+	}
+
+	return redacted
+}
+
+func benchmarkRedactor(b *testing.B, input string, legacy bool) {
+	redactor := NewRedactor(ioutil.Discard)
+	redactor.AddValueRedactions(redactorBenchmarkValueRedactions)
+	redactor.AddRedactions(redactorBenchmarkRedactions)
+
+	inputBytes := []byte(input)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(inputBytes)))
+	b.ResetTimer()
+
+	for iteration := 0; iteration < b.N; iteration++ {
+		if legacy {
+			redactor.output.Write([]byte(legacyRedact(redactor, string(inputBytes)))) // nolint: errcheck
+		} else {
+			redactor.Write(inputBytes) // nolint: errcheck
+		}
+	}
+}
+
+func BenchmarkRedactorNoMatch(b *testing.B) {
+	benchmarkRedactor(b, redactorBenchmarkNoMatchInput, false)
+}
+
+func BenchmarkRedactorNoMatchLegacy(b *testing.B) {
+	benchmarkRedactor(b, redactorBenchmarkNoMatchInput, true)
+}
+
+func BenchmarkRedactorMatch(b *testing.B) {
+	benchmarkRedactor(b, redactorBenchmarkMatchInput, false)
+}
+
+func BenchmarkRedactorMatchLegacy(b *testing.B) {
+	benchmarkRedactor(b, redactorBenchmarkMatchInput, true)
+}
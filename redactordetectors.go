@@ -0,0 +1,323 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"math"
+	"regexp"
+)
+
+// a built-in detector of secrets of a common shape. detected secrets are replaced with [redacted:<detector>]
+type RedactorDetector string
+
+const (
+
+	// JSON web tokens
+	RedactorDetectorJWT RedactorDetector = "jwt"
+
+	// the credentials of Authorization: Bearer / Basic headers
+	RedactorDetectorAuthorization RedactorDetector = "authorization"
+
+	// AWS access key IDs, and secret access keys assigned to an aws_secret_access_key key
+	RedactorDetectorAWSKeys RedactorDetector = "aws-keys"
+
+	// PEM private key blocks
+	RedactorDetectorPrivateKey RedactorDetector = "private-key"
+
+	// credit card numbers, written unbroken or in their usual groups, validated with the Luhn checksum
+	RedactorDetectorCreditCard RedactorDetector = "credit-card"
+
+	// tokens of 20 or more base64 characters (with '=' only as trailing padding), mixing upper case, lower case
+	// and digits, whose entropy is high
+	RedactorDetectorHighEntropy RedactorDetector = "high-entropy"
+)
+
+const (
+	redactorHighEntropyMinLength = 20
+
+	// fraction of the highest possible entropy for a token of its length
+	redactorHighEntropyThreshold = 0.8
+)
+
+var redactorAWSAccessKeyIDPrefixes = [][]byte{
+	[]byte("AKIA"), []byte("ASIA"), []byte("AGPA"), []byte("AIDA"), []byte("AROA"),
+	[]byte("AIPA"), []byte("ANPA"), []byte("ANVA"), []byte("A3T"),
+}
+
+// appends the spans of the secrets found in the input. must not allocate if nothing is found
+type redactorDetectorFunc func(spans []redactorSpan, input []byte, rule *redactorRule) []redactorSpan
+
+var redactorDetectors = map[RedactorDetector]redactorDetectorFunc{
+	RedactorDetectorJWT: newRedactorPatternDetector(func(input []byte) bool {
+		return bytes.Contains(input, []byte("eyJ"))
+	}, `eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`),
+
+	RedactorDetectorAuthorization: newRedactorPatternDetector(func(input []byte) bool {
+		return containsFoldASCII(input, []byte("authorization"))
+	}, `(?i)\bauthorization\b["']?\s*[:=]\s*[\["']*\s*(?:bearer|basic)\s+([A-Za-z0-9._~+/=-]+)`),
+
+	RedactorDetectorAWSKeys: newRedactorPatternDetector(func(input []byte) bool {
+		for _, accessKeyIDPrefix := range redactorAWSAccessKeyIDPrefixes {
+			if bytes.Contains(input, accessKeyIDPrefix) {
+				return true
+			}
+		}
+
+		return containsFoldASCII(input, []byte("secret"))
+	},
+		`\b(?:AKIA|ASIA|AGPA|AIDA|AROA|AIPA|ANPA|ANVA|A3T[A-Z0-9])[A-Z0-9]{16}\b`,
+		`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})\b`),
+
+	// a block cut short (e.g. by the end of a write) is redacted to the end of the input
+	RedactorDetectorPrivateKey: newRedactorPatternDetector(func(input []byte) bool {
+		return bytes.Contains(input, []byte("PRIVATE KEY-----"))
+	}, `-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----(?s:.*?)(?:-----END [A-Z0-9 ]*PRIVATE KEY-----|$)`),
+
+	RedactorDetectorCreditCard:  detectCreditCards,
+	RedactorDetectorHighEntropy: detectHighEntropyTokens,
+}
+
+// returns a detector which masks the last group of each pattern (or all of the match if it has none), if
+// the prefilter passes
+func newRedactorPatternDetector(prefilter func([]byte) bool, patterns ...string) redactorDetectorFunc {
+	var compiledPatterns []*regexp.Regexp
+	for _, pattern := range patterns {
+		compiledPatterns = append(compiledPatterns, regexp.MustCompile(pattern))
+	}
+
+	return func(spans []redactorSpan, input []byte, rule *redactorRule) []redactorSpan {
+		if !prefilter(input) {
+			return spans
+		}
+
+		for _, compiledPattern := range compiledPatterns {
+			groupIndex := compiledPattern.NumSubexp()
+
+			for _, matchIndices := range compiledPattern.FindAllSubmatchIndex(input, -1) {
+				spans = append(spans, redactorSpan{
+					start: matchIndices[2*groupIndex],
+					end:   matchIndices[2*groupIndex+1],
+					rule:  rule,
+				})
+			}
+		}
+
+		return spans
+	}
+}
+
+// runs of 13 to 19 digits - either unbroken, or in groups of 4-4-4-4 (or 4-6-5, as amex numbers are written)
+// separated by single spaces or dashes - of a card issuer (starting with 2 to 6) and passing the Luhn checksum
+func detectCreditCards(spans []redactorSpan, input []byte, rule *redactorRule) []redactorSpan {
+	for start := 0; start < len(input); start++ {
+		if !isDigit(input[start]) || (start > 0 && isWordByte(input[start-1])) {
+			continue
+		}
+
+		var digits [19]byte
+		digitCount := 0
+		end := start
+
+		// the lengths of the groups of digits, and the separator between them
+		var groupLengths [5]int
+		groupCount := 1
+		var separator byte
+
+		for position := start; position < len(input); position++ {
+			if isDigit(input[position]) {
+				if digitCount < len(digits) {
+					digits[digitCount] = input[position] - '0'
+				}
+
+				if groupCount <= len(groupLengths) {
+					groupLengths[groupCount-1]++
+				}
+
+				digitCount++
+				end = position + 1
+				continue
+			}
+
+			isSeparator := input[position] == ' ' || input[position] == '-'
+			if !isSeparator || position+1 >= len(input) || !isDigit(input[position+1]) {
+				break
+			}
+
+			// a run mixing separators is treated as not being a number
+			if separator != 0 && input[position] != separator {
+				groupCount = len(groupLengths) + 1
+			}
+
+			separator = input[position]
+			groupCount++
+		}
+
+		if digitCount >= 13 &&
+			digitCount <= len(digits) &&
+			(end == len(input) || !isWordByte(input[end])) &&
+			isCreditCardGrouping(groupLengths[:], groupCount) &&
+			digits[0] >= 2 && digits[0] <= 6 &&
+			isLuhnValid(digits[:digitCount]) {
+
+			spans = append(spans, redactorSpan{
+				start: start,
+				end:   end,
+				rule:  rule,
+			})
+		}
+
+		start = end
+	}
+
+	return spans
+}
+
+// whether the lengths of the groups of digits are those of how card numbers are written
+func isCreditCardGrouping(groupLengths []int, groupCount int) bool {
+	switch groupCount {
+	case 1:
+		return true
+	case 3:
+		return groupLengths[0] == 4 && groupLengths[1] == 6 && groupLengths[2] == 5
+	case 4:
+		return groupLengths[0] == 4 && groupLengths[1] == 4 && groupLengths[2] == 4 && groupLengths[3] == 4
+	default:
+		return false
+	}
+}
+
+func isLuhnValid(digits []byte) bool {
+	sum := 0
+
+	for digitIndex := range digits {
+		digit := int(digits[len(digits)-1-digitIndex])
+		if digitIndex%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+
+		sum += digit
+	}
+
+	return sum%10 == 0
+}
+
+func detectHighEntropyTokens(spans []redactorSpan, input []byte, rule *redactorRule) []redactorSpan {
+	for start := 0; start < len(input); start++ {
+		if !isBase64Byte(input[start]) {
+			continue
+		}
+
+		end := start
+		for end < len(input) && isBase64Byte(input[end]) {
+			end++
+		}
+
+		// '=' is only part of a token as its padding - elsewhere it separates, e.g. a key from its value
+		paddingEnd := end
+		for paddingEnd < len(input) && paddingEnd-end < 2 && input[paddingEnd] == '=' {
+			paddingEnd++
+		}
+
+		if paddingEnd == len(input) || (!isBase64Byte(input[paddingEnd]) && input[paddingEnd] != '=') {
+			end = paddingEnd
+		}
+
+		if end-start >= redactorHighEntropyMinLength && isHighEntropyToken(input[start:end]) {
+			spans = append(spans, redactorSpan{
+				start: start,
+				end:   end,
+				rule:  rule,
+			})
+		}
+
+		start = end
+	}
+
+	return spans
+}
+
+func isHighEntropyToken(token []byte) bool {
+	var counts [256]int
+	hasUpper, hasLower, hasDigit := false, false, false
+
+	for _, tokenByte := range token {
+		counts[tokenByte]++
+
+		switch {
+		case tokenByte >= 'A' && tokenByte <= 'Z':
+			hasUpper = true
+		case tokenByte >= 'a' && tokenByte <= 'z':
+			hasLower = true
+		case isDigit(tokenByte):
+			hasDigit = true
+		}
+	}
+
+	if !hasUpper || !hasLower || !hasDigit {
+		return false
+	}
+
+	// shannon entropy, in bits per byte
+	entropy := 0.0
+	for _, count := range counts {
+		if count > 0 {
+			probability := float64(count) / float64(len(token))
+			entropy -= probability * math.Log2(probability)
+		}
+	}
+
+	// the highest possible is of all bytes distinct, up to the 64 of the alphabet
+	maxEntropy := math.Log2(math.Min(float64(len(token)), 64))
+
+	return entropy >= redactorHighEntropyThreshold*maxEntropy
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func isWordByte(b byte) bool {
+	return isDigit(b) || b == '_' || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+func isBase64Byte(b byte) bool {
+	return isWordByte(b) || b == '+' || b == '/' || b == '-'
+}
+
+// whether the input contains the lower case literal, in any case
+func containsFoldASCII(input []byte, lowerLiteral []byte) bool {
+	for start := 0; start+len(lowerLiteral) <= len(input); start++ {
+		matched := true
+
+		for literalIndex, literalByte := range lowerLiteral {
+			if toLowerASCII(input[start+literalIndex]) != literalByte {
+				matched = false
+				break
+			}
+		}
+
+		if matched {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// encodings in which redactions may appear in the output, besides as is
+type RedactorEncoding int
+
+const (
+
+	// escaped as in a JSON string - e.g. by JSONFormatter, which escapes the fields of entries twice
+	RedactorEncodingJSON RedactorEncoding = 1 << iota
+
+	// percent-encoded, as in a query or a path
+	RedactorEncodingURL
+
+	// base64-encoded (standard or URL alphabet, padded or not), on its own or as part of a longer encoded value
+	// such as a basic authorization header
+	RedactorEncodingBase64
+
+	RedactorEncodingAll = RedactorEncodingJSON | RedactorEncodingURL | RedactorEncodingBase64
+)
+
+// shorter parts of longer base64 encoded values would match too much
+const redactorMinBase64AlignedLength = 6
+
+var redactorPercentEncodingRegex = regexp.MustCompile(`%[0-9A-F]{2}`)
+
+// returns the distinct encoded variants of a redaction (other than the redaction itself)
+func getRedactorEncodedVariants(redaction string, encodings RedactorEncoding) []string {
+	var encodedVariants []string
+
+	addVariant := func(encodedVariant string) {
+		if encodedVariant != "" &&
+			encodedVariant != redaction &&
+			!containsString(encodedVariants, encodedVariant) {
+			encodedVariants = append(encodedVariants, encodedVariant)
+		}
+	}
+
+	if encodings&RedactorEncodingJSON != 0 {
+		escaped := escapeRedactorJSON(redaction, true)
+
+		addVariant(escaped)
+		addVariant(escapeRedactorJSON(escaped, true))
+		addVariant(escapeRedactorJSON(redaction, false))
+		addVariant(escapeRedactorJSONNonASCII(escapeRedactorJSON(redaction, false)))
+	}
+
+	if encodings&RedactorEncodingURL != 0 {
+		for _, escaped := range []string{url.QueryEscape(redaction), url.PathEscape(redaction)} {
+			addVariant(escaped)
+			addVariant(redactorPercentEncodingRegex.ReplaceAllStringFunc(escaped, strings.ToLower))
+		}
+	}
+
+	if encodings&RedactorEncodingBase64 != 0 {
+		for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+			addVariant(encoding.EncodeToString([]byte(redaction)))
+			addVariant(encoding.WithPadding(base64.NoPadding).EncodeToString([]byte(redaction)))
+
+			for offset := 0; offset < 3; offset++ {
+				addVariant(encodeRedactorBase64Aligned(encoding, redaction, offset))
+			}
+		}
+	}
+
+	return encodedVariants
+}
+
+func escapeRedactorJSON(value string, escapeHTML bool) string {
+	buffer := bytes.Buffer{}
+
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(escapeHTML)
+	encoder.Encode(value) // nolint: errcheck
+
+	// without the quotes and the newline
+	escaped := buffer.String()
+	return escaped[1 : len(escaped)-2]
+}
+
+// as by encoders which escape all non ASCII characters
+func escapeRedactorJSONNonASCII(value string) string {
+	builder := strings.Builder{}
+
+	for _, character := range value {
+		if character < 0x80 {
+			builder.WriteRune(character)
+			continue
+		}
+
+		if first, second := utf16.EncodeRune(character); first != unicode.ReplacementChar {
+			fmt.Fprintf(&builder, `\u%04x\u%04x`, first, second)
+		} else {
+			fmt.Fprintf(&builder, `\u%04x`, character)
+		}
+	}
+
+	return builder.String()
+}
+
+// the characters of the redaction's encoding when it's preceded by offset bytes in the encoded value - only those
+// which depend on the redaction alone, so that it's matched wherever it is in a longer encoded value
+func encodeRedactorBase64Aligned(encoding *base64.Encoding, redaction string, offset int) string {
+	prefixedRedaction := strings.Repeat("\x00", offset) + redaction
+
+	// the last incomplete group depends on what follows
+	completeLength := len(prefixedRedaction) / 3 * 3
+	encoded := encoding.EncodeToString([]byte(prefixedRedaction[:completeLength]))
+
+	// the first group depends on the prefix's bytes, except for the characters which encode the redaction's
+	// bits alone
+	prefixLength := []int{0, 2, 3}[offset]
+	if len(encoded)-prefixLength < redactorMinBase64AlignedLength {
+		return ""
+	}
+
+	return encoded[prefixLength:]
+}
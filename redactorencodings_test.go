@@ -0,0 +1,128 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+const redactorEncodingsTestSecret = `pa"ss\wörd<&>`
+
+type redactorEncodingsSuite struct {
+	suite.Suite
+}
+
+func (suite *redactorEncodingsSuite) TestJSONEncoding() {
+	buf := new(bytes.Buffer)
+
+	redactor := NewRedactor(buf)
+	redactor.AddRedactionsWithOptions([]string{redactorEncodingsTestSecret}, RedactorRuleOptions{
+		Encodings: RedactorEncodingJSON,
+	})
+
+	loggerInstance, err := NewLoggerus("test", logrus.DebugLevel, redactor, &JSONFormatter{})
+	suite.Require().NoError(err)
+
+	loggerInstance.InfoWith("Connecting",
+		"password", redactorEncodingsTestSecret,
+		"config", map[string]string{"password": redactorEncodingsTestSecret})
+
+	entry := struct {
+		More map[string]string `json:"more"`
+	}{}
+	suite.Require().NoError(json.Unmarshal(buf.Bytes(), &entry))
+
+	config := map[string]string{}
+	suite.Require().NoError(json.Unmarshal([]byte(entry.More["config"]), &config))
+
+	suite.Require().Equal("*****", entry.More["password"])
+	suite.Require().Equal("*****", config["password"])
+
+	// as escaped by other encoders
+	suite.Require().Equal(`{"password":"*****"}`,
+		string(redactor.redact([]byte(`{"password":"pa\"ss\\wörd<&>"}`))))
+}
+
+func (suite *redactorEncodingsSuite) TestURLAndBase64Encodings() {
+	redactor := NewRedactor(new(bytes.Buffer))
+	redactor.AddRedactionsWithOptions([]string{redactorEncodingsTestSecret}, RedactorRuleOptions{
+		Encodings: RedactorEncodingURL | RedactorEncodingBase64,
+	})
+
+	for _, testCase := range []struct {
+		input    string
+		expected string
+	}{
+		{
+			"GET /login?password=" + url.QueryEscape(redactorEncodingsTestSecret) + "&user=admin",
+			"GET /login?password=*****&user=admin",
+		},
+		{
+			"GET /secrets/" + strings.ToLower(url.PathEscape(redactorEncodingsTestSecret)),
+			"GET /secrets/*****",
+		},
+		{
+			"token " + base64.StdEncoding.EncodeToString([]byte(redactorEncodingsTestSecret)) + " used",
+			"token ***** used",
+		},
+		{
+			"token " + base64.RawURLEncoding.EncodeToString([]byte(redactorEncodingsTestSecret)) + " used",
+			"token ***** used",
+		},
+	} {
+		suite.Require().Equal(testCase.expected, string(redactor.redact([]byte(testCase.input))))
+	}
+}
+
+func (suite *redactorEncodingsSuite) TestBase64EncodingWithinLongerValues() {
+	redactor := NewRedactor(new(bytes.Buffer))
+	redactor.AddRedactionsWithOptions([]string{redactorEncodingsTestSecret}, RedactorRuleOptions{
+		Encodings: RedactorEncodingBase64,
+	})
+
+	// e.g. basic authorization headers, wherever the secret falls relative to the groups of the encoding
+	for _, user := range []string{"a", "ab", "abc", "admin", "admin1"} {
+		encoded := base64.StdEncoding.EncodeToString([]byte(user + ":" + redactorEncodingsTestSecret + "!"))
+		redacted := string(redactor.redact([]byte("Authorization: Basic " + encoded)))
+
+		// what's left is at most the groups shared with the user and the character after the secret
+		redactedParts := strings.Split(strings.TrimPrefix(redacted, "Authorization: Basic "), "*****")
+		suite.Require().Len(redactedParts, 2, user)
+		suite.Require().LessOrEqual(len(redactedParts[0]), (len(user)+1)/3*4+4, user)
+		suite.Require().LessOrEqual(len(redactedParts[1]), 8, user)
+	}
+}
+
+func (suite *redactorEncodingsSuite) TestEncodingsAreOptIn() {
+	redactor := NewRedactor(new(bytes.Buffer))
+	redactor.AddRedactions([]string{redactorEncodingsTestSecret})
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(redactorEncodingsTestSecret))
+	suite.Require().Equal(encoded, string(redactor.redact([]byte(encoded))))
+}
+
+func TestRedactorEncodingsTestSuite(t *testing.T) {
+	suite.Run(t, new(redactorEncodingsSuite))
+}
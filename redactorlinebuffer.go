@@ -0,0 +1,165 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+)
+
+type RedactorLineBufferingConfig struct {
+
+	// an incomplete line growing beyond this many bytes is redacted and written as is (defaults to 64KiB)
+	MaxSize int
+
+	// an incomplete line is redacted and written as is once held for this long (defaults to 1 second)
+	Timeout time.Duration
+}
+
+// holds the incomplete line of the writes to a redactor, so that only complete lines are redacted
+type redactorLineBuffer struct {
+	redactor *Redactor
+	config   RedactorLineBufferingConfig
+
+	lock    sync.Mutex
+	pending []byte
+	timer   *time.Timer
+
+	// of a write when the timeout passed, returned by the next write or flush
+	err error
+}
+
+func newRedactorLineBuffer(redactor *Redactor, config RedactorLineBufferingConfig) (*redactorLineBuffer, error) {
+	if config.MaxSize < 0 || config.Timeout < 0 {
+		return nil, errors.New("line buffering max size and timeout must not be negative")
+	}
+
+	if config.MaxSize == 0 {
+		config.MaxSize = 64 * 1024
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+
+	return &redactorLineBuffer{
+		redactor: redactor,
+		config:   config,
+	}, nil
+}
+
+func (b *redactorLineBuffer) write(p []byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	err := b.err
+	b.err = nil
+
+	// complete lines aren't copied
+	if len(b.pending) == 0 && len(p) > 0 && p[len(p)-1] == '\n' {
+		if writeErr := b.redactor.writeRedacted(p); writeErr != nil {
+			err = writeErr
+		}
+
+		return err
+	}
+
+	b.pending = append(b.pending, p...)
+
+	completeLength := bytes.LastIndexByte(b.pending, '\n') + 1
+	if len(b.pending)-completeLength > b.config.MaxSize {
+		completeLength = len(b.pending)
+	}
+
+	if completeLength > 0 {
+		if writeErr := b.redactor.writeRedacted(b.pending[:completeLength]); writeErr != nil {
+			err = writeErr
+		}
+
+		b.pending = append(b.pending[:0], b.pending[completeLength:]...)
+	}
+
+	// the timeout counts from when the incomplete line started being held
+	if len(b.pending) == 0 {
+		b.stopTimer()
+	} else if b.timer == nil {
+
+		// assigned before the callback can take the lock
+		var timer *time.Timer
+		timer = time.AfterFunc(b.config.Timeout, func() {
+			b.onTimeout(timer)
+		})
+
+		b.timer = timer
+	}
+
+	return err
+}
+
+func (b *redactorLineBuffer) flush() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	err := b.err
+	b.err = nil
+
+	if writeErr := b.writePending(); writeErr != nil {
+		err = writeErr
+	}
+
+	return err
+}
+
+func (b *redactorLineBuffer) onTimeout(timer *time.Timer) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// the timer may have fired while being stopped
+	if b.timer != timer {
+		return
+	}
+
+	b.timer = nil
+
+	if err := b.writePending(); err != nil {
+		b.err = err
+	}
+}
+
+// must be called with the lock held
+func (b *redactorLineBuffer) writePending() error {
+	b.stopTimer()
+
+	if len(b.pending) == 0 {
+		return nil
+	}
+
+	err := b.redactor.writeRedacted(b.pending)
+	b.pending = b.pending[:0]
+
+	return err
+}
+
+// must be called with the lock held
+func (b *redactorLineBuffer) stopTimer() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+}
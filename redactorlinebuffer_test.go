@@ -0,0 +1,109 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type redactorLineBufferSuite struct {
+	suite.Suite
+	output   *testClosingOutput
+	redactor *Redactor
+}
+
+func (suite *redactorLineBufferSuite) SetupTest() {
+	suite.output = &testClosingOutput{}
+	suite.redactor = NewRedactor(suite.output)
+	suite.redactor.AddRedactions([]string{"hunter2"})
+	suite.redactor.AddValueRedactions([]string{"password"})
+}
+
+func (suite *redactorLineBufferSuite) TestSecretsSplitAcrossWrites() {
+	suite.Require().NoError(suite.redactor.EnableLineBuffering(RedactorLineBufferingConfig{}))
+
+	for _, chunk := range []string{"user=a pass", "word=hun", "ter2 token hun", "ter2\nnext", "\n"} {
+		bytesWritten, err := suite.redactor.Write([]byte(chunk))
+		suite.Require().NoError(err)
+		suite.Require().Equal(len(chunk), bytesWritten)
+	}
+
+	suite.Require().Equal("user=a password=[redacted] token *****\nnext\n", suite.output.getContents())
+}
+
+func (suite *redactorLineBufferSuite) TestMaxSize() {
+	suite.Require().NoError(suite.redactor.EnableLineBuffering(RedactorLineBufferingConfig{MaxSize: 8}))
+
+	suite.redactor.Write([]byte("hunter")) // nolint: errcheck
+	suite.Require().Empty(suite.output.getContents())
+
+	// too long to hold
+	suite.redactor.Write([]byte("2 and more")) // nolint: errcheck
+	suite.Require().Equal("***** and more", suite.output.getContents())
+}
+
+func (suite *redactorLineBufferSuite) TestTimeout() {
+	suite.Require().NoError(suite.redactor.EnableLineBuffering(RedactorLineBufferingConfig{
+		Timeout: 50 * time.Millisecond,
+	}))
+
+	suite.redactor.Write([]byte("prompt: hunter2")) // nolint: errcheck
+	suite.Require().Empty(suite.output.getContents())
+
+	suite.Require().Eventually(func() bool {
+		return suite.output.getContents() == "prompt: *****"
+	}, time.Second, 10*time.Millisecond)
+
+	// complete lines aren't held
+	suite.redactor.Write([]byte(" done\n")) // nolint: errcheck
+	suite.Require().Equal("prompt: ***** done\n", suite.output.getContents())
+}
+
+func (suite *redactorLineBufferSuite) TestFlushAndClose() {
+	suite.Require().NoError(suite.redactor.EnableLineBuffering(RedactorLineBufferingConfig{}))
+
+	suite.redactor.Write([]byte("partial hunter2")) // nolint: errcheck
+	suite.Require().Empty(suite.output.getContents())
+
+	suite.Require().NoError(suite.redactor.Flush())
+	suite.Require().Equal("partial *****", suite.output.getContents())
+	suite.Require().Equal(1, suite.output.getFlushes())
+
+	suite.redactor.Write([]byte(" more")) // nolint: errcheck
+	suite.Require().NoError(suite.redactor.Close())
+	suite.Require().Equal("partial ***** more", suite.output.getContents())
+	suite.Require().True(suite.output.closed)
+}
+
+func (suite *redactorLineBufferSuite) TestDisable() {
+	suite.Require().Error(suite.redactor.EnableLineBuffering(RedactorLineBufferingConfig{MaxSize: -1}))
+	suite.Require().NoError(suite.redactor.EnableLineBuffering(RedactorLineBufferingConfig{}))
+
+	suite.redactor.Write([]byte("held")) // nolint: errcheck
+	suite.Require().NoError(suite.redactor.DisableLineBuffering())
+	suite.Require().Equal("held", suite.output.getContents())
+
+	suite.redactor.Write([]byte(" hunter")) // nolint: errcheck
+	suite.Require().Equal("held hunter", suite.output.getContents())
+}
+
+func TestRedactorLineBufferTestSuite(t *testing.T) {
+	suite.Run(t, new(redactorLineBufferSuite))
+}
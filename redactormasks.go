@@ -0,0 +1,92 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"unicode/utf8"
+)
+
+const (
+	redactorMaskCharacter = '*'
+
+	// bytes of the digest kept by the HMAC mask
+	redactorHMACMaskDigestLength = 8
+)
+
+// RedactorMask appends what a secret is replaced with to dst
+type RedactorMask func(dst []byte, secret []byte) []byte
+
+// NewRedactorReplacementMask replaces secrets with a fixed string
+func NewRedactorReplacementMask(replacement string) RedactorMask {
+	return func(dst []byte, secret []byte) []byte {
+		return append(dst, replacement...)
+	}
+}
+
+// NewRedactorPartialMask keeps the first and last characters of secrets, masking the rest with a * per
+// character. secrets too short to mask any character are masked entirely
+func NewRedactorPartialMask(keepFirst int, keepLast int) RedactorMask {
+	return func(dst []byte, secret []byte) []byte {
+		characterCount := utf8.RuneCount(secret)
+		if characterCount <= keepFirst+keepLast {
+			return append(dst, bytes.Repeat([]byte{redactorMaskCharacter}, characterCount)...)
+		}
+
+		for characterIndex := 0; len(secret) > 0; characterIndex++ {
+			_, characterLength := utf8.DecodeRune(secret)
+
+			if characterIndex < keepFirst || characterIndex >= characterCount-keepLast {
+				dst = append(dst, secret[:characterLength]...)
+			} else {
+				dst = append(dst, redactorMaskCharacter)
+			}
+
+			secret = secret[characterLength:]
+		}
+
+		return dst
+	}
+}
+
+// NewRedactorFixedLengthMask masks secrets with a fixed number of *, hiding their length
+func NewRedactorFixedLengthMask(length int) RedactorMask {
+	mask := bytes.Repeat([]byte{redactorMaskCharacter}, length)
+
+	return func(dst []byte, secret []byte) []byte {
+		return append(dst, mask...)
+	}
+}
+
+// NewRedactorHMACMask replaces secrets with (the start of) their keyed HMAC-SHA256 digest, as
+// [hmac:<16 hex digits>], so that occurrences of a secret can be correlated without revealing it
+func NewRedactorHMACMask(key []byte) RedactorMask {
+	key = append([]byte{}, key...)
+
+	return func(dst []byte, secret []byte) []byte {
+		digester := hmac.New(sha256.New, key)
+		digester.Write(secret) // nolint: errcheck
+
+		dst = append(dst, "[hmac:"...)
+		dst = append(dst, hex.EncodeToString(digester.Sum(nil)[:redactorHMACMaskDigestLength])...)
+
+		return append(dst, ']')
+	}
+}
@@ -0,0 +1,509 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// golang regex doesn't support lookarounds, so we will check things manually
+const (
+	redactorMatchKeyWithSeparatorTemplate = `\\*[\'"]?(?i)(?:%s)\\*[\'"]?\s*[=:]\s*`
+	redactorMatchValue                    = `\'[^\']*?\'|\"[^\"]*\"|\S*`
+)
+
+// the group of regex redactions which is masked, if they have one
+const redactorRegexSecretGroupName = "secret"
+
+// the only non ASCII runes which case fold to ASCII - the kelvin sign (k) and the long s
+var redactorNonASCIIFolds = [][]byte{[]byte("\u212a"), []byte("\u017f")}
+
+// the redactions of a redactor, compiled once into matchers which each take a single pass over the input
+type redactorRules struct {
+
+	// matches `valueRedaction=[value]` or `valueRedaction: [value]` w/wo single/double quotes, for all value
+	// redactions. each key is a named group (see valueKeyGroups), and the value is the last group
+	valueMatcher    *regexp.Regexp
+	valueGroupIndex int
+	valueKeyGroups  []redactorKeyGroup
+
+	// the value redactions the value matcher was compiled from, and the group of each
+	valueKeys            []string
+	valueKeyGroupIndices []int
+
+	// when the value redactions are all plain keys, the value matcher only runs (anchored) where a key is
+	keyMatcher           *ahoCorasickMatcher
+	anchoredValueMatcher *regexp.Regexp
+
+	// by pattern index
+	literalMatcher *ahoCorasickMatcher
+	literalRules   []*redactorRule
+
+	// the patterns the literal matcher was compiled from - the redactions and their encoded variants
+	literals []string
+
+	detectors []redactorRulesDetector
+
+	// each replacing the secret group of its matches, or whole matches if it has none
+	regexRules []redactorRulesRegex
+
+	// of the distinct rule names
+	ruleStats []*redactorRuleStats
+
+	metricsHook RedactorMetricsHook
+}
+
+// a compiled rule, by which matches are replaced
+type redactorRule struct {
+	mask  RedactorMask
+	stats *redactorRuleStats
+}
+
+type redactorKeyGroup struct {
+	groupIndex int
+	rule       *redactorRule
+}
+
+type redactorRulesDetector struct {
+	detect redactorDetectorFunc
+	rule   *redactorRule
+}
+
+type redactorRulesRegex struct {
+	matcher *regexp.Regexp
+	rule    *redactorRule
+
+	// 0 (the whole match) if the regex has no secret group
+	secretGroupIndex int
+}
+
+// what redactor rules are compiled from
+type redactorRulesSpec struct {
+	redactions             []redactorRuleSpec
+	valueRedactions        []redactorRuleSpec
+	detectors              []redactorRuleSpec
+	regexRedactions        []redactorRuleSpec
+	replacementString      string
+	valueReplacementString string
+	metricsHook            RedactorMetricsHook
+
+	// by rule name, kept across compilations. rules whose names are missing are added to it
+	ruleStats map[string]*redactorRuleStats
+
+	// the rules compiled before, if any, whose matchers are reused where possible
+	previousRules *redactorRules
+}
+
+// a part of the input to replace
+type redactorSpan struct {
+	start int
+	end   int
+	rule  *redactorRule
+}
+
+func compileRedactorRules(spec *redactorRulesSpec) (*redactorRules, error) {
+	rules := redactorRules{
+		metricsHook: spec.metricsHook,
+	}
+
+	// the matchers only depend on the keys and literals, so they're reused while those are unchanged
+	previousRules := spec.previousRules
+
+	if valueRedactions := spec.valueRedactions; len(valueRedactions) > 0 {
+		rules.valueKeys = getRedactorRuleSpecValues(valueRedactions)
+
+		if previousRules != nil && equalStrings(previousRules.valueKeys, rules.valueKeys) {
+			rules.valueMatcher = previousRules.valueMatcher
+			rules.valueGroupIndex = previousRules.valueGroupIndex
+			rules.keyMatcher = previousRules.keyMatcher
+			rules.anchoredValueMatcher = previousRules.anchoredValueMatcher
+			rules.valueKeyGroupIndices = previousRules.valueKeyGroupIndices
+		} else if err := rules.compileValueMatchers(); err != nil {
+			return nil, err
+		}
+
+		for valueRedactionIndex, valueRedaction := range valueRedactions {
+			rule := rules.newRule(spec, &valueRedaction.options, valueRedaction.value, spec.valueReplacementString)
+
+			rules.valueKeyGroups = append(rules.valueKeyGroups, redactorKeyGroup{
+				groupIndex: rules.valueKeyGroupIndices[valueRedactionIndex],
+				rule:       rule,
+			})
+		}
+	}
+
+	if redactions := spec.redactions; len(redactions) > 0 {
+		for _, redaction := range redactions {
+			rule := rules.newRule(spec, &redaction.options, redactorDefaultRuleName, spec.replacementString)
+
+			// the encoded variants of a redaction are redacted by its rule
+			rules.literals = append(rules.literals, redaction.value)
+			rules.literalRules = append(rules.literalRules, rule)
+
+			for _, encodedVariant := range getRedactorEncodedVariants(redaction.value, redaction.options.Encodings) {
+				rules.literals = append(rules.literals, encodedVariant)
+				rules.literalRules = append(rules.literalRules, rule)
+			}
+		}
+
+		if previousRules != nil && equalStrings(previousRules.literals, rules.literals) {
+			rules.literalMatcher = previousRules.literalMatcher
+		} else {
+			patterns := make([][]byte, 0, len(rules.literals))
+			for _, literal := range rules.literals {
+				patterns = append(patterns, []byte(literal))
+			}
+
+			rules.literalMatcher = newAhoCorasickMatcher(patterns, false)
+		}
+	}
+
+	for _, detector := range spec.detectors {
+		rules.detectors = append(rules.detectors, redactorRulesDetector{
+			detect: redactorDetectors[RedactorDetector(detector.value)],
+			rule: rules.newRule(spec,
+				&detector.options,
+				detector.value,
+				fmt.Sprintf("[redacted:%s]", detector.value)),
+		})
+	}
+
+	for _, regexRedaction := range spec.regexRedactions {
+		regexRule := redactorRulesRegex{
+			matcher: regexRedaction.regex,
+			rule: rules.newRule(spec,
+				&regexRedaction.options,
+				redactorDefaultRegexRuleName,
+				spec.replacementString),
+		}
+
+		for groupIndex, groupName := range regexRedaction.regex.SubexpNames() {
+			if groupName == redactorRegexSecretGroupName {
+				regexRule.secretGroupIndex = groupIndex
+			}
+		}
+
+		rules.regexRules = append(rules.regexRules, regexRule)
+	}
+
+	return &rules, nil
+}
+
+func (rr *redactorRules) compileValueMatchers() error {
+	keyPatterns := make([]string, 0, len(rr.valueKeys))
+	keys := make([][]byte, 0, len(rr.valueKeys))
+
+	for valueKeyIndex, key := range rr.valueKeys {
+		if key != "" && regexp.QuoteMeta(key) == key {
+			keys = append(keys, []byte(key))
+		}
+
+		// value redactions are patterns, but an invalid one is matched literally rather than breaking the rest
+		if _, err := regexp.Compile(key); err != nil {
+			key = regexp.QuoteMeta(key)
+		}
+
+		keyPatterns = append(keyPatterns, fmt.Sprintf(`(?P<key%d>%s)`, valueKeyIndex, key))
+	}
+
+	matchKeyWithSeparator := fmt.Sprintf(redactorMatchKeyWithSeparatorTemplate, strings.Join(keyPatterns, "|"))
+	valuePattern := fmt.Sprintf(`(%s)(%s)`, matchKeyWithSeparator, redactorMatchValue)
+	valueMatcher, err := regexp.Compile(valuePattern)
+	if err != nil {
+		return fmt.Errorf("failed to compile value redactions, %w", err)
+	}
+
+	rr.valueMatcher = valueMatcher
+	rr.valueGroupIndex = rr.valueMatcher.NumSubexp()
+
+	keyGroupIndices := map[string]int{}
+	for groupIndex, groupName := range rr.valueMatcher.SubexpNames() {
+		keyGroupIndices[groupName] = groupIndex
+	}
+
+	for valueKeyIndex := range rr.valueKeys {
+		rr.valueKeyGroupIndices = append(rr.valueKeyGroupIndices, keyGroupIndices[fmt.Sprintf("key%d", valueKeyIndex)])
+	}
+
+	if len(keys) == len(rr.valueKeys) {
+		anchoredValueMatcher, err := regexp.Compile(`^` + valuePattern)
+		if err != nil {
+			return fmt.Errorf("failed to compile value redactions, %w", err)
+		}
+
+		rr.keyMatcher = newAhoCorasickMatcher(keys, true)
+		rr.anchoredValueMatcher = anchoredValueMatcher
+	}
+
+	return nil
+}
+
+func (rr *redactorRules) newRule(spec *redactorRulesSpec,
+	options *RedactorRuleOptions,
+	defaultName string,
+	defaultReplacement string) *redactorRule {
+
+	rule := redactorRule{
+		mask: options.Mask,
+	}
+
+	if rule.mask == nil {
+		rule.mask = NewRedactorReplacementMask(defaultReplacement)
+	}
+
+	name := options.Name
+	if name == "" {
+		name = defaultName
+	}
+
+	rule.stats = spec.ruleStats[name]
+	if rule.stats == nil {
+		rule.stats = &redactorRuleStats{name: name}
+		spec.ruleStats[name] = rule.stats
+	}
+
+	if !containsRedactorRuleStats(rr.ruleStats, rule.stats) {
+		rr.ruleStats = append(rr.ruleStats, rule.stats)
+	}
+
+	return &rule
+}
+
+// returns the input itself (without allocating) if nothing matched
+func (rr *redactorRules) redact(input []byte) []byte {
+	var spans []redactorSpan
+
+	if rr.valueMatcher != nil {
+		spans = rr.appendValueSpans(spans, input)
+	}
+
+	if rr.literalMatcher != nil {
+		for _, match := range rr.literalMatcher.appendMatches(nil, input) {
+			spans = append(spans, redactorSpan{
+				start: match.start,
+				end:   match.end,
+				rule:  rr.literalRules[match.pattern],
+			})
+		}
+	}
+
+	for _, detector := range rr.detectors {
+		spans = detector.detect(spans, input, detector.rule)
+	}
+
+	for _, regexRule := range rr.regexRules {
+		spans = regexRule.appendSpans(spans, input)
+	}
+
+	if len(spans) == 0 {
+		return input
+	}
+
+	return rr.replaceSpans(input, spans)
+}
+
+func (rr *redactorRulesRegex) appendSpans(spans []redactorSpan, input []byte) []redactorSpan {
+	if rr.secretGroupIndex == 0 {
+		for _, matchIndices := range rr.matcher.FindAllIndex(input, -1) {
+			spans = append(spans, redactorSpan{
+				start: matchIndices[0],
+				end:   matchIndices[1],
+				rule:  rr.rule,
+			})
+		}
+
+		return spans
+	}
+
+	for _, matchIndices := range rr.matcher.FindAllSubmatchIndex(input, -1) {
+
+		// the secret group may be optional
+		if matchIndices[2*rr.secretGroupIndex] == -1 {
+			continue
+		}
+
+		spans = append(spans, redactorSpan{
+			start: matchIndices[2*rr.secretGroupIndex],
+			end:   matchIndices[2*rr.secretGroupIndex+1],
+			rule:  rr.rule,
+		})
+	}
+
+	return spans
+}
+
+func (rr *redactorRules) appendValueSpans(spans []redactorSpan, input []byte) []redactorSpan {
+
+	// the key matcher only folds ASCII case
+	if rr.keyMatcher == nil ||
+		bytes.Contains(input, redactorNonASCIIFolds[0]) ||
+		bytes.Contains(input, redactorNonASCIIFolds[1]) {
+
+		for _, matchIndices := range rr.valueMatcher.FindAllSubmatchIndex(input, -1) {
+			spans = append(spans, rr.getValueSpan(matchIndices, 0))
+		}
+
+		return spans
+	}
+
+	// keys inside a matched value are part of it, as when matching the value matcher throughout the input
+	position := 0
+
+	for _, keyMatch := range rr.keyMatcher.appendMatches(nil, input) {
+		if keyMatch.start < position {
+			continue
+		}
+
+		matchIndices := rr.anchoredValueMatcher.FindSubmatchIndex(input[keyMatch.start:])
+		if matchIndices == nil {
+			continue
+		}
+
+		spans = append(spans, rr.getValueSpan(matchIndices, keyMatch.start))
+		position = keyMatch.start + matchIndices[1]
+	}
+
+	return spans
+}
+
+// the span of the value of a match of the value matcher at the offset, with the rule of the key which matched
+func (rr *redactorRules) getValueSpan(matchIndices []int, offset int) redactorSpan {
+	valueSpan := redactorSpan{
+		start: offset + matchIndices[2*rr.valueGroupIndex],
+		end:   offset + matchIndices[2*rr.valueGroupIndex+1],
+	}
+
+	for _, keyGroup := range rr.valueKeyGroups {
+		if matchIndices[2*keyGroup.groupIndex] != -1 {
+			valueSpan.rule = keyGroup.rule
+			break
+		}
+	}
+
+	return valueSpan
+}
+
+// replaces the spans of the input, merging overlapping ones into the replacement of the first (a value
+// may be empty, in which case its replacement is inserted), and records the hits of their rules
+func (rr *redactorRules) replaceSpans(input []byte, spans []redactorSpan) []byte {
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+
+		return spans[i].end > spans[j].end
+	})
+
+	mergedSpans := spans[:1]
+	for _, span := range spans[1:] {
+		lastSpan := &mergedSpans[len(mergedSpans)-1]
+
+		if span.start < lastSpan.end || span.start == lastSpan.start {
+			if span.end > lastSpan.end {
+				lastSpan.end = span.end
+			}
+
+			continue
+		}
+
+		mergedSpans = append(mergedSpans, span)
+	}
+
+	redacted := make([]byte, 0, len(input))
+	position := 0
+	now := time.Now()
+
+	for _, span := range mergedSpans {
+		redacted = append(redacted, input[position:span.start]...)
+		redacted = span.rule.mask(redacted, input[span.start:span.end])
+		position = span.end
+
+		span.rule.stats.recordHit(now)
+		if rr.metricsHook != nil {
+			rr.metricsHook(span.rule.stats.name)
+		}
+	}
+
+	return append(redacted, input[position:]...)
+}
+
+func compileRedactorRegex(pattern string) (*regexp.Regexp, error) {
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex redaction %q, %w", pattern, err)
+	}
+
+	secretGroups := 0
+	for _, groupName := range regex.SubexpNames() {
+		if groupName == redactorRegexSecretGroupName {
+			secretGroups++
+		}
+	}
+
+	if secretGroups > 1 {
+		return nil, fmt.Errorf("invalid regex redaction %q, it has more than one %q group",
+			pattern,
+			redactorRegexSecretGroupName)
+	}
+
+	// its empty matches would be replaced between every character
+	if regex.MatchString("") {
+		return nil, fmt.Errorf("invalid regex redaction %q, it matches the empty string", pattern)
+	}
+
+	return regex, nil
+}
+
+// value redactions are patterns of keys. those added in code are matched literally if they're invalid, but
+// those loaded from a file are validated, as regex redactions are
+func validateRedactorValueRedaction(valueRedaction string) error {
+	if valueRedaction == "" {
+		return errors.New("value redactions must not be empty")
+	}
+
+	regex, err := regexp.Compile(valueRedaction)
+	if err != nil {
+		return fmt.Errorf("invalid value redaction %q, %w", valueRedaction, err)
+	}
+
+	// it would match the separator of every key
+	if regex.MatchString("") {
+		return fmt.Errorf("invalid value redaction %q, it matches the empty string", valueRedaction)
+	}
+
+	return nil
+}
+
+func equalStrings(values []string, otherValues []string) bool {
+	if len(values) != len(otherValues) {
+		return false
+	}
+
+	for valueIndex := range values {
+		if values[valueIndex] != otherValues[valueIndex] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,299 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/nuclio/logger"
+	"gopkg.in/yaml.v3"
+)
+
+const redactorDefaultRulesFilePollInterval = 10 * time.Second
+
+type RedactorRulesFileConfig struct {
+
+	// path of the YAML (or JSON) rules file
+	Path string
+
+	// how often the file is checked for changes (defaults to 10 seconds)
+	PollInterval time.Duration
+
+	// if set, failures to reload the file are logged with it (the previous rules are kept regardless)
+	Logger logger.Logger
+}
+
+// the rules file format, e.g.:
+//
+//	redactions:
+//	  - some-api-key
+//	valueRedactions:
+//	  - password
+//	  - client_secret
+//	regexRedactions:
+//	  - 'sk_live_[0-9a-zA-Z]{24}'
+//	  - 'postgres://[^:/]+:(?P<secret>[^@]+)@'
+type redactorRulesFile struct {
+	Redactions      []string `yaml:"redactions"`
+	ValueRedactions []string `yaml:"valueRedactions"`
+	RegexRedactions []string `yaml:"regexRedactions"`
+}
+
+// the validated rules of a rules file
+type redactorFileRules struct {
+	redactions      []redactorRuleSpec
+	valueRedactions []redactorRuleSpec
+	regexRedactions []redactorRuleSpec
+}
+
+// LoadRulesFile replaces the rules of the rules file with those of the file (in addition to the rules added
+// with AddRedactions etc.). if the file is invalid, the rules are left as they were
+func (r *Redactor) LoadRulesFile(filePath string) error {
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read redaction rules, %w", err)
+	}
+
+	fileRules, err := parseRedactorRulesFile(contents)
+	if err != nil {
+		return fmt.Errorf("failed to load redaction rules from %s, %w", filePath, err)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if err := r.setFileRules(fileRules); err != nil {
+		return fmt.Errorf("failed to load redaction rules from %s, %w", filePath, err)
+	}
+
+	return nil
+}
+
+// WatchRulesFile loads the rules file, and reloads it whenever its contents change. polling (rather than
+// file system notifications) works with any volume, e.g. a mounted ConfigMap
+func (r *Redactor) WatchRulesFile(config RedactorRulesFileConfig) error {
+	if config.PollInterval < 0 {
+		return errors.New("poll interval must not be negative")
+	}
+
+	if config.PollInterval == 0 {
+		config.PollInterval = redactorDefaultRulesFilePollInterval
+	}
+
+	contents, err := ioutil.ReadFile(config.Path)
+	if err != nil {
+		return fmt.Errorf("failed to read redaction rules, %w", err)
+	}
+
+	fileRules, err := parseRedactorRulesFile(contents)
+	if err != nil {
+		return fmt.Errorf("failed to load redaction rules from %s, %w", config.Path, err)
+	}
+
+	r.lock.Lock()
+
+	if err := r.setFileRules(fileRules); err != nil {
+		r.lock.Unlock()
+		return fmt.Errorf("failed to load redaction rules from %s, %w", config.Path, err)
+	}
+
+	// the previous watcher (if any) is replaced within the same critical section, so that concurrent calls
+	// don't each start one. it's closed while holding the lock, so it doesn't apply a reload after it's replaced
+	previousStopChan, previousStopped := r.rulesFileStopChan, r.rulesFileStopped
+	if previousStopChan != nil {
+		close(previousStopChan)
+	}
+
+	r.rulesFileStopChan = make(chan struct{})
+	r.rulesFileStopped = make(chan struct{})
+
+	go r.watchRulesFile(config, contents, r.rulesFileStopChan, r.rulesFileStopped)
+
+	r.lock.Unlock()
+
+	// waited for without holding the lock, which the watcher takes to reload
+	if previousStopped != nil {
+		<-previousStopped
+	}
+
+	return nil
+}
+
+// StopWatchingRulesFile stops reloading the rules file. its rules are kept
+func (r *Redactor) StopWatchingRulesFile() {
+	r.lock.Lock()
+
+	stopChan, stopped := r.rulesFileStopChan, r.rulesFileStopped
+	r.rulesFileStopChan = nil
+	r.rulesFileStopped = nil
+
+	// closed while holding the lock, so that the watcher doesn't apply a reload after it's stopped
+	if stopChan != nil {
+		close(stopChan)
+	}
+
+	r.lock.Unlock()
+
+	// waited for without holding the lock, which the watcher takes to reload
+	if stopped != nil {
+		<-stopped
+	}
+}
+
+func (r *Redactor) watchRulesFile(config RedactorRulesFileConfig,
+	loadedContents []byte,
+	stopChan chan struct{},
+	stopped chan struct{}) {
+
+	defer close(stopped)
+
+	ticker := time.NewTicker(config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		contents, err := ioutil.ReadFile(config.Path)
+		if err != nil {
+
+			// e.g. while the file is being replaced
+			r.logRulesFileError(config, "Failed to read redaction rules", err)
+			continue
+		}
+
+		// invalid contents are remembered too, so that their failure is reported once
+		if bytes.Equal(contents, loadedContents) {
+			continue
+		}
+
+		loadedContents = contents
+
+		fileRules, err := parseRedactorRulesFile(contents)
+		if err != nil {
+			r.logRulesFileError(config, "Failed to reload redaction rules, keeping the previous ones", err)
+			continue
+		}
+
+		r.lock.Lock()
+
+		select {
+		case <-stopChan:
+		default:
+			err = r.setFileRules(fileRules)
+		}
+
+		r.lock.Unlock()
+
+		if err != nil {
+			r.logRulesFileError(config, "Failed to reload redaction rules, keeping the previous ones", err)
+		}
+	}
+}
+
+func (r *Redactor) logRulesFileError(config RedactorRulesFileConfig, message string, err error) {
+	if config.Logger != nil {
+		config.Logger.WarnWith(message, "path", config.Path, "err", err.Error())
+	}
+}
+
+// must be called with the lock held. if the rules can't be compiled, the previous ones are kept
+func (r *Redactor) setFileRules(fileRules *redactorFileRules) error {
+	previousFileRules := redactorFileRules{
+		redactions:      r.fileRedactions,
+		valueRedactions: r.fileValueRedactions,
+		regexRedactions: r.fileRegexRedactions,
+	}
+
+	r.fileRedactions = fileRules.redactions
+	r.fileValueRedactions = fileRules.valueRedactions
+	r.fileRegexRedactions = fileRules.regexRedactions
+
+	if err := r.compileRules(); err != nil {
+		r.fileRedactions = previousFileRules.redactions
+		r.fileValueRedactions = previousFileRules.valueRedactions
+		r.fileRegexRedactions = previousFileRules.regexRedactions
+
+		return err
+	}
+
+	return nil
+}
+
+func parseRedactorRulesFile(contents []byte) (parsedFileRules *redactorFileRules, err error) {
+
+	// the file may be reloaded in the background, where a panic of the parser would crash the process
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			parsedFileRules = nil
+			err = fmt.Errorf("failed to parse redaction rules, %v", recovered)
+		}
+	}()
+
+	rulesFile := redactorRulesFile{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+
+	// an empty file has no rules
+	if err := decoder.Decode(&rulesFile); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse redaction rules, %w", err)
+	}
+
+	fileRules := redactorFileRules{}
+
+	for _, redaction := range rulesFile.Redactions {
+		if redaction == "" {
+			return nil, errors.New("redactions must not be empty")
+		}
+
+		fileRules.redactions = addRedactorRuleSpecs(fileRules.redactions, []string{redaction}, RedactorRuleOptions{}, 0)
+	}
+
+	for _, valueRedaction := range rulesFile.ValueRedactions {
+		if err := validateRedactorValueRedaction(valueRedaction); err != nil {
+			return nil, err
+		}
+
+		fileRules.valueRedactions = addRedactorRuleSpecs(fileRules.valueRedactions,
+			[]string{valueRedaction},
+			RedactorRuleOptions{},
+			0)
+	}
+
+	for _, regexRedaction := range rulesFile.RegexRedactions {
+		regex, err := compileRedactorRegex(regexRedaction)
+		if err != nil {
+			return nil, err
+		}
+
+		fileRules.regexRedactions = append(fileRules.regexRedactions, redactorRuleSpec{
+			value: regexRedaction,
+			regex: regex,
+		})
+	}
+
+	return &fileRules, nil
+}
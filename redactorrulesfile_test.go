@@ -0,0 +1,189 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type redactorRulesFileSuite struct {
+	suite.Suite
+	tempDir   string
+	rulesPath string
+}
+
+func (suite *redactorRulesFileSuite) SetupTest() {
+	var err error
+
+	suite.tempDir, err = ioutil.TempDir("", "redactor-rules-test")
+	suite.Require().NoError(err)
+
+	suite.rulesPath = filepath.Join(suite.tempDir, "rules.yaml")
+}
+
+func (suite *redactorRulesFileSuite) TearDownTest() {
+	os.RemoveAll(suite.tempDir) // nolint: errcheck
+}
+
+func (suite *redactorRulesFileSuite) TestLoadRulesFile() {
+	suite.writeRules(`
+redactions:
+  - file-secret
+valueRedactions:
+  - password
+regexRedactions:
+  - 'sk_live_[0-9a-zA-Z]{8}'
+  - 'postgres://[^:/]+:(?P<secret>[^@]+)@'
+`)
+
+	buf := new(bytes.Buffer)
+	redactor := NewRedactor(buf)
+	redactor.AddRedactions([]string{"added-secret"})
+
+	suite.Require().NoError(redactor.LoadRulesFile(suite.rulesPath))
+
+	redactor.Write([]byte("added-secret file-secret password=1 key=sk_live_abcd1234 postgres://u:p@db")) // nolint: errcheck
+	suite.Require().Equal("***** ***** password=[redacted] key=***** postgres://u:*****@db", buf.String())
+
+	// the file's rules are kept apart from those added
+	suite.Require().Equal([]string{"added-secret"}, redactor.GetRedactions())
+
+	// JSON is YAML too, and loading replaces the file's rules
+	suite.writeRules(`{"redactions": ["other-secret"]}`)
+	suite.Require().NoError(redactor.LoadRulesFile(suite.rulesPath))
+
+	buf.Reset()
+	redactor.Write([]byte("added-secret file-secret other-secret")) // nolint: errcheck
+	suite.Require().Equal("***** file-secret *****", buf.String())
+}
+
+func (suite *redactorRulesFileSuite) TestInvalidRulesFile() {
+	redactor := NewRedactor(new(bytes.Buffer))
+
+	suite.writeRules(`{"redactions": ["file-secret"]}`)
+	suite.Require().NoError(redactor.LoadRulesFile(suite.rulesPath))
+
+	for _, invalidRules := range []string{
+		`redactions: [file-secret`,
+		`redaction: [file-secret]`,
+		`redactions: [""]`,
+		`valueRedactions: [""]`,
+		`valueRedactions: ["pass(word"]`,
+		`valueRedactions: ["(token)?"]`,
+		`regexRedactions: ["sk_[a-z"]`,
+		`regexRedactions: ["[0-9]*"]`,
+		`regexRedactions: ["(?P<secret>a)(?P<secret>b)"]`,
+	} {
+		suite.writeRules(invalidRules)
+		suite.Require().Error(redactor.LoadRulesFile(suite.rulesPath), invalidRules)
+	}
+
+	suite.Require().Error(redactor.LoadRulesFile(filepath.Join(suite.tempDir, "missing.yaml")))
+
+	// valid on its own, but too deeply nested once combined with the rest
+	suite.writeRules(`valueRedactions: ["` + strings.Repeat("(", 998) + "key" + strings.Repeat(")", 998) + `"]`)
+	suite.Require().Error(redactor.LoadRulesFile(suite.rulesPath))
+
+	// the previous rules are kept
+	suite.Require().Equal("*****", string(redactor.redact([]byte("file-secret"))))
+}
+
+func (suite *redactorRulesFileSuite) TestWatchRulesFile() {
+	output := &testOutput{}
+	warningLogger, err := NewLoggerus("redactor", logrus.DebugLevel, output, &JSONFormatter{})
+	suite.Require().NoError(err)
+
+	redactor := NewRedactor(new(bytes.Buffer))
+
+	suite.writeRules(`redactions: [first-secret]`)
+	suite.Require().NoError(redactor.WatchRulesFile(RedactorRulesFileConfig{
+		Path:         suite.rulesPath,
+		PollInterval: 5 * time.Millisecond,
+		Logger:       warningLogger,
+	}))
+
+	suite.Require().Equal("*****", string(redactor.redact([]byte("first-secret"))))
+
+	suite.writeRules(`redactions: [second-secret]`)
+	suite.Require().Eventually(func() bool {
+		return string(redactor.redact([]byte("first-secret second-secret"))) == "first-secret *****"
+	}, time.Second, 5*time.Millisecond)
+
+	// an invalid file is reported once, and the previous rules are kept
+	suite.writeRules(`redactions: [third-secret`)
+	suite.Require().Eventually(func() bool {
+		return strings.Contains(output.getContents(), "Failed to reload redaction rules")
+	}, time.Second, 5*time.Millisecond)
+
+	time.Sleep(30 * time.Millisecond)
+	suite.Require().Equal(1, strings.Count(output.getContents(), "Failed to reload redaction rules"))
+	suite.Require().Equal("*****", string(redactor.redact([]byte("second-secret"))))
+
+	// once stopped, changes are ignored
+	suite.Require().NoError(redactor.Close())
+	suite.writeRules(`redactions: [fourth-secret]`)
+
+	time.Sleep(30 * time.Millisecond)
+	suite.Require().Equal("*****", string(redactor.redact([]byte("second-secret"))))
+}
+
+func (suite *redactorRulesFileSuite) TestConcurrentWatchRulesFile() {
+	redactor := NewRedactor(new(bytes.Buffer))
+	suite.writeRules(`redactions: [first-secret]`)
+
+	var waitGroup sync.WaitGroup
+
+	for watcherIndex := 0; watcherIndex < 64; watcherIndex++ {
+		waitGroup.Add(1)
+
+		go func() {
+			defer waitGroup.Done()
+
+			suite.Require().NoError(redactor.WatchRulesFile(RedactorRulesFileConfig{
+				Path:         suite.rulesPath,
+				PollInterval: 5 * time.Millisecond,
+			}))
+		}()
+	}
+
+	waitGroup.Wait()
+
+	// a single watcher is left running, so once stopped changes are ignored
+	redactor.StopWatchingRulesFile()
+	suite.writeRules(`redactions: [second-secret]`)
+
+	time.Sleep(30 * time.Millisecond)
+	suite.Require().Equal("***** second-secret", string(redactor.redact([]byte("first-secret second-secret"))))
+}
+
+func (suite *redactorRulesFileSuite) writeRules(contents string) {
+	suite.Require().NoError(ioutil.WriteFile(suite.rulesPath, []byte(contents), 0644))
+}
+
+func TestRedactorRulesFileTestSuite(t *testing.T) {
+	suite.Run(t, new(redactorRulesFileSuite))
+}
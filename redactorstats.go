@@ -0,0 +1,188 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/nuclio/logger"
+)
+
+// the names of redactions and regex redactions which weren't given one
+const (
+	redactorDefaultRuleName      = "redactions"
+	redactorDefaultRegexRuleName = "regex-redactions"
+)
+
+// RedactorMetricsHook is called with the name of the rule of every redacted secret, e.g. to increment a counter.
+// it's called while writing, so it must be quick
+type RedactorMetricsHook func(ruleName string)
+
+type RedactorRuleStats struct {
+	Name string
+
+	// secrets redacted by rules of this name
+	Hits uint64
+
+	// zero if never hit
+	LastHitTime time.Time
+}
+
+// the statistics of the rules of a name, updated atomically while writing
+type redactorRuleStats struct {
+
+	// first, for 64 bit alignment
+	hits            uint64
+	lastHitUnixNano int64
+
+	name string
+}
+
+func (s *redactorRuleStats) recordHit(now time.Time) {
+	atomic.AddUint64(&s.hits, 1)
+	atomic.StoreInt64(&s.lastHitUnixNano, now.UnixNano())
+}
+
+func (s *redactorRuleStats) get() RedactorRuleStats {
+	stats := RedactorRuleStats{
+		Name: s.name,
+		Hits: atomic.LoadUint64(&s.hits),
+	}
+
+	if lastHitUnixNano := atomic.LoadInt64(&s.lastHitUnixNano); lastHitUnixNano != 0 {
+		stats.LastHitTime = time.Unix(0, lastHitUnixNano)
+	}
+
+	return stats
+}
+
+// GetRuleStats returns the statistics of the current rules, by rule name (see RedactorRuleOptions.Name)
+func (r *Redactor) GetRuleStats() []RedactorRuleStats {
+	var stats []RedactorRuleStats
+
+	for _, ruleStats := range r.getRules().ruleStats {
+		stats = append(stats, ruleStats.get())
+	}
+
+	return stats
+}
+
+// SetMetricsHook sets a hook called for every redacted secret (nil removes it)
+func (r *Redactor) SetMetricsHook(metricsHook RedactorMetricsHook) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.metricsHook = metricsHook
+	r.compileRules() // nolint: errcheck
+}
+
+// EnableStatsSummary logs the hits of every rule since the previous summary (by rule name - never the secrets
+// themselves) with the logger, every interval, until disabled or the redactor is closed
+func (r *Redactor) EnableStatsSummary(loggerInstance logger.Logger, interval time.Duration) error {
+	if loggerInstance == nil || interval <= 0 {
+		return errors.New("stats summary requires a logger and a positive interval")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stopStatsSummary()
+
+	r.statsSummaryStopChan = make(chan struct{})
+	r.statsSummaryStopped = make(chan struct{})
+
+	go r.summarizeStats(loggerInstance, interval, r.statsSummaryStopChan, r.statsSummaryStopped)
+
+	return nil
+}
+
+// DisableStatsSummary stops logging summaries
+func (r *Redactor) DisableStatsSummary() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.stopStatsSummary()
+}
+
+// must be called with the lock held
+func (r *Redactor) stopStatsSummary() {
+	if r.statsSummaryStopChan == nil {
+		return
+	}
+
+	close(r.statsSummaryStopChan)
+	<-r.statsSummaryStopped
+
+	r.statsSummaryStopChan = nil
+	r.statsSummaryStopped = nil
+}
+
+// doesn't take the lock, so that stopping it while holding the lock doesn't deadlock
+func (r *Redactor) summarizeStats(loggerInstance logger.Logger,
+	interval time.Duration,
+	stopChan chan struct{},
+	stopped chan struct{}) {
+
+	defer close(stopped)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	summarizedHits := map[*redactorRuleStats]uint64{}
+
+	for {
+		select {
+		case <-stopChan:
+			return
+		case <-ticker.C:
+		}
+
+		// e.g. "password 3, redactions 0". the summary is usually written through this redactor, so the rule
+		// names aren't followed by separators, lest value rules (named after their key) redact it
+		var hits []string
+		ruleStats := r.getRules().ruleStats
+
+		// rebuilt on every summary, so that the stats of removed rules aren't kept
+		currentHits := make(map[*redactorRuleStats]uint64, len(ruleStats))
+		for _, stats := range ruleStats {
+			totalHits := atomic.LoadUint64(&stats.hits)
+
+			hits = append(hits, fmt.Sprintf("%s %d", stats.name, totalHits-summarizedHits[stats]))
+			currentHits[stats] = totalHits
+		}
+
+		summarizedHits = currentHits
+
+		loggerInstance.InfoWith("Redaction rules summary",
+			"hits", strings.Join(hits, ", "),
+			"interval", interval.String())
+	}
+}
+
+func containsRedactorRuleStats(ruleStats []*redactorRuleStats, stats *redactorRuleStats) bool {
+	for _, candidateStats := range ruleStats {
+		if candidateStats == stats {
+			return true
+		}
+	}
+
+	return false
+}
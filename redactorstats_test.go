@@ -0,0 +1,160 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type redactorStatsSuite struct {
+	suite.Suite
+}
+
+func (suite *redactorStatsSuite) TestRuleStats() {
+	redactor := NewRedactor(ioutil.Discard)
+	redactor.AddRedactions([]string{"secret-a", "secret-b"})
+	redactor.AddRedactionsWithOptions([]string{"db-pass"}, RedactorRuleOptions{Name: "db"})
+	redactor.AddValueRedactions([]string{"password"})
+	suite.Require().NoError(redactor.EnableDetectors(RedactorDetectorAWSKeys))
+
+	beforeWrite := time.Now()
+	redactor.Write([]byte("secret-a secret-b db-pass password=1 password=2 password=3")) // nolint: errcheck
+
+	stats := suite.getRuleStats(redactor)
+	suite.Require().Len(stats, 4)
+	suite.Require().Equal(uint64(2), stats["redactions"].Hits)
+	suite.Require().Equal(uint64(1), stats["db"].Hits)
+	suite.Require().Equal(uint64(3), stats["password"].Hits)
+	suite.Require().Zero(stats[string(RedactorDetectorAWSKeys)].Hits)
+	suite.Require().True(stats[string(RedactorDetectorAWSKeys)].LastHitTime.IsZero())
+	suite.Require().False(stats["db"].LastHitTime.Before(beforeWrite))
+
+	// statistics survive rule updates
+	redactor.AddRedactions([]string{"secret-c"})
+	redactor.Write([]byte("secret-c")) // nolint: errcheck
+	suite.Require().Equal(uint64(3), suite.getRuleStats(redactor)["redactions"].Hits)
+
+	// the secrets aren't reported
+	for _, ruleStats := range redactor.GetRuleStats() {
+		suite.Require().NotContains(ruleStats.Name, "secret")
+	}
+}
+
+func (suite *redactorStatsSuite) TestMetricsHook() {
+	var hookLock sync.Mutex
+	hits := map[string]int{}
+
+	redactor := NewRedactor(ioutil.Discard)
+	redactor.AddValueRedactionsWithOptions([]string{"password", "passwd"}, RedactorRuleOptions{Name: "passwords"})
+	redactor.SetMetricsHook(func(ruleName string) {
+		hookLock.Lock()
+		defer hookLock.Unlock()
+
+		hits[ruleName]++
+	})
+
+	redactor.Write([]byte("password=1 passwd=2")) // nolint: errcheck
+	suite.Require().Equal(map[string]int{"passwords": 2}, hits)
+
+	redactor.SetMetricsHook(nil)
+	redactor.Write([]byte("password=1")) // nolint: errcheck
+	suite.Require().Equal(map[string]int{"passwords": 2}, hits)
+}
+
+func (suite *redactorStatsSuite) TestStatsSummary() {
+	output := &testOutput{}
+	summaryLogger, err := NewJSONLoggerus("redactor", logrus.DebugLevel, output)
+	suite.Require().NoError(err)
+
+	redactor := NewRedactor(ioutil.Discard)
+	redactor.AddRedactions([]string{"hunter2"})
+
+	suite.Require().Error(redactor.EnableStatsSummary(summaryLogger, 0))
+	suite.Require().NoError(redactor.EnableStatsSummary(summaryLogger, 10*time.Millisecond))
+
+	redactor.Write([]byte("hunter2 hunter2")) // nolint: errcheck
+
+	// the hits since the previous summary are logged
+	suite.Require().Eventually(func() bool {
+		return strings.Contains(output.getContents(), `"hits":"redactions 2"`)
+	}, time.Second, 5*time.Millisecond)
+
+	suite.Require().Eventually(func() bool {
+		return strings.Contains(output.getContents(), `"hits":"redactions 0"`)
+	}, time.Second, 5*time.Millisecond)
+
+	suite.Require().NoError(redactor.Close())
+	summaries := strings.Count(output.getContents(), "Redaction rules summary")
+
+	time.Sleep(30 * time.Millisecond)
+	suite.Require().Equal(summaries, strings.Count(output.getContents(), "Redaction rules summary"))
+	suite.Require().NotContains(output.getContents(), "hunter2")
+}
+
+func (suite *redactorStatsSuite) TestStatsSummaryThroughRedactor() {
+	output := &testOutput{}
+
+	redactor := NewRedactor(output)
+	redactor.AddValueRedactions([]string{"password"})
+	redactor.AddRedactions([]string{"hunter2"})
+
+	summaryLogger, err := NewJSONLoggerus("redactor", logrus.DebugLevel, redactor)
+	suite.Require().NoError(err)
+
+	redactor.Write([]byte("password=1 hunter2\n")) // nolint: errcheck
+	suite.Require().NoError(redactor.EnableStatsSummary(summaryLogger, 5*time.Millisecond))
+
+	suite.Require().Eventually(func() bool {
+		return strings.Count(output.getContents(), "Redaction rules summary") >= 3
+	}, time.Second, 5*time.Millisecond)
+
+	redactor.DisableStatsSummary()
+
+	// the summaries didn't redact themselves
+	suite.Require().Equal(uint64(1), suite.getRuleStats(redactor)["password"].Hits)
+	suite.Require().Equal(uint64(1), suite.getRuleStats(redactor)["redactions"].Hits)
+
+	lines := strings.Split(strings.TrimSpace(output.getContents()), "\n")
+	suite.Require().Equal("password=[redacted] *****", lines[0])
+
+	entry := struct {
+		More map[string]string `json:"more"`
+	}{}
+	suite.Require().NoError(json.Unmarshal([]byte(lines[1]), &entry))
+	suite.Require().Equal("password 1, redactions 1", entry.More["hits"])
+}
+
+func (suite *redactorStatsSuite) getRuleStats(redactor *Redactor) map[string]RedactorRuleStats {
+	stats := map[string]RedactorRuleStats{}
+	for _, ruleStats := range redactor.GetRuleStats() {
+		stats[ruleStats.Name] = ruleStats
+	}
+
+	return stats
+}
+
+func TestRedactorStatsTestSuite(t *testing.T) {
+	suite.Run(t, new(redactorStatsSuite))
+}
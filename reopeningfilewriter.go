@@ -0,0 +1,187 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+)
+
+type ReopeningFileWriterConfig struct {
+
+	// path of the log file
+	Path string
+
+	// signals which trigger a reopen of the file, typically syscall.SIGHUP (none if empty)
+	Signals []os.Signal
+
+	// permissions of newly created log files (defaults to 0644)
+	FileMode os.FileMode
+}
+
+// an io.Writer writing to a file which is reopened on demand, for use with external rotation (e.g. logrotate)
+type ReopeningFileWriter struct {
+	config     ReopeningFileWriterConfig
+	lock       sync.Mutex
+	file       *os.File
+	signalChan chan os.Signal
+	stopChan   chan struct{}
+	stopped    chan struct{}
+	closed     bool
+}
+
+func NewReopeningFileWriter(config ReopeningFileWriterConfig) (*ReopeningFileWriter, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("reopening file writer requires a path")
+	}
+
+	if config.FileMode == 0 {
+		config.FileMode = 0644
+	}
+
+	newReopeningFileWriter := ReopeningFileWriter{
+		config:     config,
+		signalChan: make(chan os.Signal, 1),
+		stopChan:   make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+
+	if err := newReopeningFileWriter.openFile(); err != nil {
+		return nil, err
+	}
+
+	if len(config.Signals) > 0 {
+		signal.Notify(newReopeningFileWriter.signalChan, config.Signals...)
+	}
+
+	go newReopeningFileWriter.signalLoop()
+
+	return &newReopeningFileWriter, nil
+}
+
+// Write writes p to the file. writes never interleave with a reopen, so entries are never lost or torn
+func (w *ReopeningFileWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	// a previous reopen may have failed - try again
+	if w.file == nil {
+		if err := w.openFile(); err != nil {
+			return 0, err
+		}
+	}
+
+	return w.file.Write(p)
+}
+
+// Reopen closes the file and opens the configured path again
+func (w *ReopeningFileWriter) Reopen() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	if w.file != nil {
+		err := w.file.Close()
+		w.file = nil
+
+		if err != nil {
+			return fmt.Errorf("failed to close log file %s, %w", w.config.Path, err)
+		}
+	}
+
+	return w.openFile()
+}
+
+// Flush commits the file to stable storage
+func (w *ReopeningFileWriter) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	return w.file.Sync()
+}
+
+// Close stops listening for signals and closes the file
+func (w *ReopeningFileWriter) Close() error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.lock.Unlock()
+
+	signal.Stop(w.signalChan)
+	close(w.stopChan)
+	<-w.stopped
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+
+	return err
+}
+
+func (w *ReopeningFileWriter) signalLoop() {
+	defer close(w.stopped)
+
+	for {
+		select {
+		case <-w.signalChan:
+
+			// nowhere to report the error to - the next write will retry opening the file
+			w.Reopen() // nolint: errcheck
+
+		case <-w.stopChan:
+			return
+		}
+	}
+}
+
+func (w *ReopeningFileWriter) openFile() error {
+	if err := os.MkdirAll(filepath.Dir(w.config.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory, %w", err)
+	}
+
+	file, err := os.OpenFile(w.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, w.config.FileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s, %w", w.config.Path, err)
+	}
+
+	w.file = file
+
+	return nil
+}
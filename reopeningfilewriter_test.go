@@ -0,0 +1,150 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type reopeningFileWriterSuite struct {
+	suite.Suite
+	tempDir string
+	path    string
+}
+
+func (suite *reopeningFileWriterSuite) SetupTest() {
+	var err error
+
+	suite.tempDir, err = ioutil.TempDir("", "loggerus-reopening-")
+	suite.Require().NoError(err)
+
+	suite.path = filepath.Join(suite.tempDir, "app.log")
+}
+
+func (suite *reopeningFileWriterSuite) TearDownTest() {
+	os.RemoveAll(suite.tempDir) // nolint: errcheck
+}
+
+func (suite *reopeningFileWriterSuite) TestReopenOnSignal() {
+	writer, err := NewReopeningFileWriter(ReopeningFileWriterConfig{Path: suite.path})
+	suite.Require().NoError(err)
+
+	_, err = writer.Write([]byte("before\n"))
+	suite.Require().NoError(err)
+
+	// what logrotate does - move the file and signal
+	suite.Require().NoError(os.Rename(suite.path, suite.path+".1"))
+	writer.signalChan <- syscall.SIGHUP
+
+	suite.Require().Eventually(func() bool {
+		_, err := os.Stat(suite.path)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, err = writer.Write([]byte("after\n"))
+	suite.Require().NoError(err)
+	suite.Require().NoError(writer.Close())
+
+	suite.Require().Equal("before\n", suite.readFile(suite.path+".1"))
+	suite.Require().Equal("after\n", suite.readFile(suite.path))
+}
+
+func (suite *reopeningFileWriterSuite) TestConcurrentWritesDuringReopen() {
+	writer, err := NewReopeningFileWriter(ReopeningFileWriterConfig{Path: suite.path})
+	suite.Require().NoError(err)
+
+	loggerInstance, err := NewJSONLoggerus("test", logrus.InfoLevel, NewRedactor(writer))
+	suite.Require().NoError(err)
+
+	goroutines := 8
+	entriesPerGoroutine := 200
+
+	waitGroup := sync.WaitGroup{}
+	for goroutineIndex := 0; goroutineIndex < goroutines; goroutineIndex++ {
+		waitGroup.Add(1)
+		go func(goroutineIndex int) {
+			defer waitGroup.Done()
+			for entryIndex := 0; entryIndex < entriesPerGoroutine; entryIndex++ {
+				loggerInstance.InfoWith("Entry", "goroutine", goroutineIndex, "entry", entryIndex)
+			}
+		}(goroutineIndex)
+	}
+
+	// rotate repeatedly while writes are in flight
+	var rotatedPaths []string
+	for rotationIndex := 0; rotationIndex < 10; rotationIndex++ {
+		rotatedPath := fmt.Sprintf("%s.%d", suite.path, rotationIndex)
+		suite.Require().NoError(os.Rename(suite.path, rotatedPath))
+		suite.Require().NoError(writer.Reopen())
+		rotatedPaths = append(rotatedPaths, rotatedPath)
+	}
+
+	waitGroup.Wait()
+	suite.Require().NoError(writer.Close())
+
+	var lines []string
+	for _, path := range append(rotatedPaths, suite.path) {
+		lines = append(lines, strings.Split(strings.TrimSpace(suite.readFile(path)), "\n")...)
+	}
+
+	entries := 0
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		// every line must be a complete entry
+		suite.Require().True(strings.HasPrefix(line, "{") && strings.HasSuffix(line, "}"), line)
+		entries++
+	}
+
+	suite.Require().Equal(goroutines*entriesPerGoroutine, entries)
+}
+
+func (suite *reopeningFileWriterSuite) TestWriteAfterClose() {
+	writer, err := NewReopeningFileWriter(ReopeningFileWriterConfig{
+		Path:    suite.path,
+		Signals: []os.Signal{syscall.SIGHUP},
+	})
+	suite.Require().NoError(err)
+	suite.Require().NoError(writer.Close())
+
+	_, err = writer.Write([]byte("closed\n"))
+	suite.Require().Equal(os.ErrClosed, err)
+}
+
+func (suite *reopeningFileWriterSuite) readFile(path string) string {
+	contents, err := ioutil.ReadFile(path)
+	suite.Require().NoError(err)
+
+	return string(contents)
+}
+
+func TestReopeningFileWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(reopeningFileWriterSuite))
+}
@@ -0,0 +1,471 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const rotatedFileTimestampFormat = "2006-01-02T15-04-05.000"
+
+type RotatingFileWriterConfig struct {
+
+	// path of the active log file. rotated files are placed beside it
+	Path string
+
+	// rotate once the active file would exceed this many bytes (0 disables size rotation)
+	MaxSize int64
+
+	// rotate every interval, aligned to the interval (0 disables time rotation)
+	RotationInterval time.Duration
+
+	// remove rotated files older than this (0 keeps them regardless of age)
+	MaxAge time.Duration
+
+	// keep at most this many rotated files (0 keeps them regardless of count)
+	MaxBackups int
+
+	// gzip rotated files in the background
+	Compress bool
+
+	// permissions of newly created log files (defaults to 0644)
+	FileMode os.FileMode
+}
+
+// an io.Writer writing to a file, rotating it by size and / or time
+type RotatingFileWriter struct {
+	config           RotatingFileWriterConfig
+	lock             sync.Mutex
+	file             *os.File
+	size             int64
+	nextRotationTime time.Time
+	postRotateChan   chan struct{}
+	postRotateDone   chan struct{}
+	closed           bool
+
+	// overridable for tests
+	now func() time.Time
+}
+
+func NewRotatingFileWriter(config RotatingFileWriterConfig) (*RotatingFileWriter, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("rotating file writer requires a path")
+	}
+
+	if config.FileMode == 0 {
+		config.FileMode = 0644
+	}
+
+	newRotatingFileWriter := RotatingFileWriter{
+		config:         config,
+		postRotateChan: make(chan struct{}, 1),
+		postRotateDone: make(chan struct{}),
+		now:            time.Now,
+	}
+
+	if err := newRotatingFileWriter.openFile(); err != nil {
+		return nil, err
+	}
+
+	go newRotatingFileWriter.postRotateLoop()
+
+	return &newRotatingFileWriter, nil
+}
+
+func (w *RotatingFileWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	if w.file == nil {
+		if err := w.openFile(); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+
+	return n, err
+}
+
+// Rotate forces a rotation of the active file
+func (w *RotatingFileWriter) Rotate() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	return w.rotate()
+}
+
+// Reopen closes and reopens the active file, without rotating it
+func (w *RotatingFileWriter) Reopen() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return os.ErrClosed
+	}
+
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+
+	return w.openFile()
+}
+
+// Flush commits the active file to stable storage
+func (w *RotatingFileWriter) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+
+	return w.file.Sync()
+}
+
+// Close closes the active file and waits for pending compression and retention to complete
+func (w *RotatingFileWriter) Close() error {
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return nil
+	}
+
+	w.closed = true
+	err := w.closeFile()
+
+	// no more rotations can happen - let the background worker drain and exit
+	close(w.postRotateChan)
+	w.lock.Unlock()
+
+	<-w.postRotateDone
+
+	return err
+}
+
+func (w *RotatingFileWriter) shouldRotate(writeSize int64) bool {
+	if w.config.MaxSize > 0 && w.size > 0 && w.size+writeSize > w.config.MaxSize {
+		return true
+	}
+
+	if w.config.RotationInterval > 0 && !w.now().Before(w.nextRotationTime) {
+
+		// an empty file isn't worth rotating, it's kept for the next interval
+		if w.size == 0 {
+			w.setNextRotationTime()
+			return false
+		}
+
+		return true
+	}
+
+	return false
+}
+
+func (w *RotatingFileWriter) rotate() error {
+	if err := w.closeFile(); err != nil {
+		return err
+	}
+
+	// nothing to rotate if the file was removed under us
+	if _, err := os.Stat(w.config.Path); err == nil {
+		if err := os.Rename(w.config.Path, w.getRotatedFilePath()); err != nil {
+			return fmt.Errorf("failed to rename log file %s, %w", w.config.Path, err)
+		}
+	}
+
+	if err := w.openFile(); err != nil {
+		return err
+	}
+
+	// request compression / retention, unless a request is already pending
+	select {
+	case w.postRotateChan <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+func (w *RotatingFileWriter) openFile() error {
+	if err := os.MkdirAll(filepath.Dir(w.config.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory, %w", err)
+	}
+
+	file, err := os.OpenFile(w.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, w.config.FileMode)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s, %w", w.config.Path, err)
+	}
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		file.Close() // nolint: errcheck
+		return fmt.Errorf("failed to stat log file %s, %w", w.config.Path, err)
+	}
+
+	w.file = file
+	w.size = fileInfo.Size()
+
+	w.setNextRotationTime()
+
+	return nil
+}
+
+func (w *RotatingFileWriter) setNextRotationTime() {
+	if w.config.RotationInterval > 0 {
+		w.nextRotationTime = w.now().Truncate(w.config.RotationInterval).Add(w.config.RotationInterval)
+	}
+}
+
+func (w *RotatingFileWriter) closeFile() error {
+	if w.file == nil {
+		return nil
+	}
+
+	err := w.file.Close()
+	w.file = nil
+	w.size = 0
+
+	return err
+}
+
+func (w *RotatingFileWriter) getRotatedFilePath() string {
+	prefix, extension := w.getRotatedFileNameParts()
+	baseName := prefix + w.now().Format(rotatedFileTimestampFormat)
+
+	// don't overwrite a file rotated within the same millisecond - follow the last one, even if retention
+	// already removed those before it, so that the names keep the rotation order
+	index := 0
+	if fileInfos, err := ioutil.ReadDir(filepath.Dir(w.config.Path)); err == nil {
+		for _, fileInfo := range fileInfos {
+			if !strings.HasPrefix(fileInfo.Name(), baseName) {
+				continue
+			}
+
+			if existingIndex, ok := parseRotatedFileIndex(strings.TrimPrefix(fileInfo.Name(), baseName),
+				extension); ok && existingIndex >= index {
+
+				index = existingIndex + 1
+			}
+		}
+	}
+
+	if index == 0 {
+		return filepath.Join(filepath.Dir(w.config.Path), baseName+extension)
+	}
+
+	return filepath.Join(filepath.Dir(w.config.Path), fmt.Sprintf("%s.%d%s", baseName, index, extension))
+}
+
+// parses what follows the timestamp in the name of a rotated file - "<ext>" for the first file rotated within
+// the same millisecond, and ".<index><ext>" for the ones after it (optionally followed by ".gz")
+func parseRotatedFileIndex(suffix string, extension string) (int, bool) {
+	suffix = strings.TrimSuffix(suffix, ".gz")
+	if !strings.HasSuffix(suffix, extension) {
+		return 0, false
+	}
+
+	suffix = strings.TrimSuffix(suffix, extension)
+	if suffix == "" {
+		return 0, true
+	}
+
+	if !strings.HasPrefix(suffix, ".") {
+		return 0, false
+	}
+
+	index, err := strconv.Atoi(suffix[1:])
+	if err != nil || index < 1 {
+		return 0, false
+	}
+
+	return index, true
+}
+
+// returns "<name>-" and "<ext>" for "<dir>/<name><ext>"
+func (w *RotatingFileWriter) getRotatedFileNameParts() (string, string) {
+	baseName := filepath.Base(w.config.Path)
+	extension := filepath.Ext(baseName)
+
+	return strings.TrimSuffix(baseName, extension) + "-", extension
+}
+
+func (w *RotatingFileWriter) postRotateLoop() {
+	defer close(w.postRotateDone)
+
+	for range w.postRotateChan {
+		w.postRotate()
+	}
+}
+
+func (w *RotatingFileWriter) postRotate() {
+	rotatedFiles, err := w.getRotatedFiles()
+	if err != nil {
+		return
+	}
+
+	var remainingFiles []rotatedFile
+	for fileIndex, rotatedFileInstance := range rotatedFiles {
+		if w.config.MaxBackups > 0 && fileIndex >= w.config.MaxBackups {
+			os.Remove(rotatedFileInstance.path) // nolint: errcheck
+			continue
+		}
+
+		if w.config.MaxAge > 0 && w.now().Sub(rotatedFileInstance.modTime) > w.config.MaxAge {
+			os.Remove(rotatedFileInstance.path) // nolint: errcheck
+			continue
+		}
+
+		remainingFiles = append(remainingFiles, rotatedFileInstance)
+	}
+
+	if !w.config.Compress {
+		return
+	}
+
+	for _, rotatedFileInstance := range remainingFiles {
+		if !strings.HasSuffix(rotatedFileInstance.path, ".gz") {
+			compressFile(rotatedFileInstance.path) // nolint: errcheck
+		}
+	}
+}
+
+type rotatedFile struct {
+	path    string
+	modTime time.Time
+
+	// of files rotated within the same millisecond, in rotation order (0 for the first, which has none)
+	index int
+}
+
+// returns rotated files, newest first
+func (w *RotatingFileWriter) getRotatedFiles() ([]rotatedFile, error) {
+	prefix, extension := w.getRotatedFileNameParts()
+
+	fileInfos, err := ioutil.ReadDir(filepath.Dir(w.config.Path))
+	if err != nil {
+		return nil, err
+	}
+
+	var rotatedFiles []rotatedFile
+	for _, fileInfo := range fileInfos {
+		fileName := fileInfo.Name()
+
+		if fileInfo.IsDir() ||
+			!strings.HasPrefix(fileName, prefix) ||
+			!(strings.HasSuffix(fileName, extension) || strings.HasSuffix(fileName, extension+".gz")) {
+			continue
+		}
+
+		// the timestamp in the name is when the file was rotated
+		timestamp := strings.TrimPrefix(fileName, prefix)
+		if len(timestamp) < len(rotatedFileTimestampFormat) {
+			continue
+		}
+
+		rotationTime, err := time.ParseInLocation(rotatedFileTimestampFormat,
+			timestamp[:len(rotatedFileTimestampFormat)],
+			time.Local)
+		if err != nil {
+			continue
+		}
+
+		index, ok := parseRotatedFileIndex(timestamp[len(rotatedFileTimestampFormat):], extension)
+		if !ok {
+			continue
+		}
+
+		rotatedFiles = append(rotatedFiles, rotatedFile{
+			path:    filepath.Join(filepath.Dir(w.config.Path), fileName),
+			modTime: rotationTime,
+			index:   index,
+		})
+	}
+
+	sort.SliceStable(rotatedFiles, func(i, j int) bool {
+		if rotatedFiles[i].modTime.Equal(rotatedFiles[j].modTime) {
+			return rotatedFiles[i].index > rotatedFiles[j].index
+		}
+		return rotatedFiles[i].modTime.After(rotatedFiles[j].modTime)
+	})
+
+	return rotatedFiles, nil
+}
+
+func compressFile(path string) error {
+	sourceFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close() // nolint: errcheck
+
+	// write to a temporary file so a crash never leaves a truncated .gz behind
+	temporaryPath := path + ".gz.tmp"
+	targetFile, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+
+	// on any failure - once renamed, there's nothing left to remove
+	defer os.Remove(temporaryPath) // nolint: errcheck
+
+	gzipWriter := gzip.NewWriter(targetFile)
+	if _, err := io.Copy(gzipWriter, sourceFile); err != nil {
+		targetFile.Close() // nolint: errcheck
+		return err
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		targetFile.Close() // nolint: errcheck
+		return err
+	}
+
+	if err := targetFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(temporaryPath, path+".gz"); err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,272 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type rotatingFileWriterSuite struct {
+	suite.Suite
+	tempDir string
+	nowLock sync.Mutex
+	now     time.Time
+}
+
+func (suite *rotatingFileWriterSuite) SetupTest() {
+	var err error
+
+	suite.tempDir, err = ioutil.TempDir("", "loggerus-rotating-")
+	suite.Require().NoError(err)
+
+	suite.now = time.Date(2021, 3, 4, 10, 0, 0, 0, time.Local)
+}
+
+func (suite *rotatingFileWriterSuite) TearDownTest() {
+	os.RemoveAll(suite.tempDir) // nolint: errcheck
+}
+
+func (suite *rotatingFileWriterSuite) TestRotateBySize() {
+	writer := suite.createWriter(RotatingFileWriterConfig{MaxSize: 10})
+
+	for _, line := range []string{"12345\n", "67890\n", "abcde\n"} {
+		_, err := writer.Write([]byte(line))
+		suite.Require().NoError(err)
+		suite.advanceClock(time.Second)
+	}
+
+	suite.Require().NoError(writer.Close())
+
+	// each write would have exceeded the max size, so each ended up in its own file
+	suite.Require().Equal("abcde\n", suite.readFile("app.log"))
+	suite.Require().Equal([]string{
+		"app-2021-03-04T10-00-01.000.log",
+		"app-2021-03-04T10-00-02.000.log",
+		"app.log",
+	}, suite.listFiles())
+	suite.Require().Equal("12345\n", suite.readFile("app-2021-03-04T10-00-01.000.log"))
+}
+
+func (suite *rotatingFileWriterSuite) TestRotateByTime() {
+	writer := suite.createWriter(RotatingFileWriterConfig{RotationInterval: time.Hour})
+
+	_, err := writer.Write([]byte("first\n"))
+	suite.Require().NoError(err)
+
+	// still within the same hour
+	suite.advanceClock(30 * time.Minute)
+	_, err = writer.Write([]byte("second\n"))
+	suite.Require().NoError(err)
+
+	// crossed the hour boundary
+	suite.advanceClock(30 * time.Minute)
+	_, err = writer.Write([]byte("third\n"))
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(writer.Close())
+
+	suite.Require().Equal("first\nsecond\n", suite.readFile("app-2021-03-04T11-00-00.000.log"))
+	suite.Require().Equal("third\n", suite.readFile("app.log"))
+}
+
+func (suite *rotatingFileWriterSuite) TestEmptyFileIsNotRotatedByTime() {
+	writer := suite.createWriter(RotatingFileWriterConfig{RotationInterval: time.Hour})
+
+	// nothing was written within the hour
+	suite.advanceClock(time.Hour)
+	_, err := writer.Write([]byte("first\n"))
+	suite.Require().NoError(err)
+
+	suite.Require().NoError(writer.Close())
+	suite.Require().Equal([]string{"app.log"}, suite.listFiles())
+	suite.Require().Equal("first\n", suite.readFile("app.log"))
+}
+
+func (suite *rotatingFileWriterSuite) TestCompressAndRetention() {
+	writer := suite.createWriter(RotatingFileWriterConfig{
+		MaxBackups: 2,
+		MaxAge:     time.Hour,
+		Compress:   true,
+	})
+
+	for index := 0; index < 4; index++ {
+		_, err := writer.Write([]byte("line\n"))
+		suite.Require().NoError(err)
+		suite.Require().NoError(writer.Rotate())
+		suite.advanceClock(time.Minute)
+	}
+
+	suite.Require().NoError(writer.Close())
+
+	// only the 2 newest backups are kept, compressed
+	suite.Require().Equal([]string{
+		"app-2021-03-04T10-02-00.000.log.gz",
+		"app-2021-03-04T10-03-00.000.log.gz",
+		"app.log",
+	}, suite.listFiles())
+
+	gzipFile, err := os.Open(filepath.Join(suite.tempDir, "app-2021-03-04T10-03-00.000.log.gz"))
+	suite.Require().NoError(err)
+	defer gzipFile.Close() // nolint: errcheck
+
+	gzipReader, err := gzip.NewReader(gzipFile)
+	suite.Require().NoError(err)
+
+	contents, err := ioutil.ReadAll(gzipReader)
+	suite.Require().NoError(err)
+	suite.Require().Equal("line\n", string(contents))
+
+	// age retention - reopen a writer two hours later and rotate
+	suite.advanceClock(2 * time.Hour)
+	writer = suite.createWriter(RotatingFileWriterConfig{MaxAge: time.Hour})
+	suite.Require().NoError(writer.Rotate())
+	suite.Require().NoError(writer.Close())
+
+	suite.Require().Equal([]string{
+		"app-2021-03-04T12-04-00.000.log",
+		"app.log",
+	}, suite.listFiles())
+}
+
+func (suite *rotatingFileWriterSuite) TestFailedCompressionLeavesNoTemporaryFile() {
+	path := filepath.Join(suite.tempDir, "app-rotated.log")
+	suite.Require().NoError(ioutil.WriteFile(path, []byte("rotated\n"), 0644))
+
+	// the compressed file can't replace a non-empty directory
+	suite.Require().NoError(os.MkdirAll(filepath.Join(path+".gz", "occupied"), 0755))
+
+	suite.Require().Error(compressFile(path))
+	suite.Require().Equal([]string{"app-rotated.log", "app-rotated.log.gz"}, suite.listFiles())
+}
+
+func (suite *rotatingFileWriterSuite) TestRetentionWithinTheSameMillisecond() {
+	writer := suite.createWriter(RotatingFileWriterConfig{MaxBackups: 3})
+
+	// the clock doesn't advance, so all are rotated within the same millisecond
+	for index := 0; index < 12; index++ {
+		_, err := writer.Write([]byte(fmt.Sprintf("line %d\n", index)))
+		suite.Require().NoError(err)
+		suite.Require().NoError(writer.Rotate())
+	}
+
+	suite.Require().NoError(writer.Close())
+
+	// the 3 newest backups are kept
+	suite.Require().Equal([]string{
+		"app-2021-03-04T10-00-00.000.10.log",
+		"app-2021-03-04T10-00-00.000.11.log",
+		"app-2021-03-04T10-00-00.000.9.log",
+		"app.log",
+	}, suite.listFiles())
+
+	suite.Require().Equal("line 11\n", suite.readFile("app-2021-03-04T10-00-00.000.11.log"))
+}
+
+func (suite *rotatingFileWriterSuite) TestReopen() {
+	writer := suite.createWriter(RotatingFileWriterConfig{})
+
+	_, err := writer.Write([]byte("before\n"))
+	suite.Require().NoError(err)
+
+	// simulate an external tool moving the file away
+	suite.Require().NoError(os.Rename(filepath.Join(suite.tempDir, "app.log"),
+		filepath.Join(suite.tempDir, "moved.log")))
+	suite.Require().NoError(writer.Reopen())
+
+	_, err = writer.Write([]byte("after\n"))
+	suite.Require().NoError(err)
+	suite.Require().NoError(writer.Close())
+
+	suite.Require().Equal("before\n", suite.readFile("moved.log"))
+	suite.Require().Equal("after\n", suite.readFile("app.log"))
+}
+
+func (suite *rotatingFileWriterSuite) TestLoggerusWithRedactor() {
+	writer := suite.createWriter(RotatingFileWriterConfig{})
+
+	redactor := NewRedactor(writer)
+	redactor.AddRedactions([]string{"secret"})
+
+	loggerInstance, err := NewJSONLoggerus("test", logrus.InfoLevel, redactor)
+	suite.Require().NoError(err)
+
+	loggerInstance.InfoWith("Something", "password", "secret")
+	suite.Require().NoError(writer.Close())
+
+	suite.Require().Contains(suite.readFile("app.log"), `"password":"*****"`)
+}
+
+func (suite *rotatingFileWriterSuite) createWriter(config RotatingFileWriterConfig) *RotatingFileWriter {
+	config.Path = filepath.Join(suite.tempDir, "app.log")
+
+	writer, err := NewRotatingFileWriter(config)
+	suite.Require().NoError(err)
+
+	// reopen so that the next rotation time is calculated with the fake clock
+	writer.now = func() time.Time {
+		suite.nowLock.Lock()
+		defer suite.nowLock.Unlock()
+
+		return suite.now
+	}
+	suite.Require().NoError(writer.Reopen())
+
+	return writer
+}
+
+// the fake clock is also read by the writer's background retention worker
+func (suite *rotatingFileWriterSuite) advanceClock(duration time.Duration) {
+	suite.nowLock.Lock()
+	defer suite.nowLock.Unlock()
+
+	suite.now = suite.now.Add(duration)
+}
+
+func (suite *rotatingFileWriterSuite) readFile(name string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(suite.tempDir, name))
+	suite.Require().NoError(err)
+
+	return string(contents)
+}
+
+func (suite *rotatingFileWriterSuite) listFiles() []string {
+	fileInfos, err := ioutil.ReadDir(suite.tempDir)
+	suite.Require().NoError(err)
+
+	var names []string
+	for _, fileInfo := range fileInfos {
+		names = append(names, fileInfo.Name())
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+func TestRotatingFileWriterTestSuite(t *testing.T) {
+	suite.Run(t, new(rotatingFileWriterSuite))
+}
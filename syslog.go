@@ -0,0 +1,435 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+type SyslogFormat int
+
+const (
+	SyslogFormatRFC5424 SyslogFormat = iota
+	SyslogFormatRFC3164
+)
+
+type SyslogFraming int
+
+const (
+
+	// RFC 6587 octet counting ("<length> <message>"), stream transports only
+	SyslogFramingOctetCounting SyslogFraming = iota
+
+	// RFC 6587 non-transparent framing (newline terminated), stream transports only
+	SyslogFramingNonTransparent
+)
+
+// syslog facilities, RFC 5424 section 6.2.1
+const (
+	SyslogFacilityKern   = 0
+	SyslogFacilityUser   = 1
+	SyslogFacilityDaemon = 3
+	SyslogFacilityLocal0 = 16
+	SyslogFacilityLocal1 = 17
+	SyslogFacilityLocal2 = 18
+	SyslogFacilityLocal3 = 19
+	SyslogFacilityLocal4 = 20
+	SyslogFacilityLocal5 = 21
+	SyslogFacilityLocal6 = 22
+	SyslogFacilityLocal7 = 23
+)
+
+const (
+	syslogNilValue                = "-"
+	syslogDefaultStructuredDataID = "more@32473"
+	syslogRFC5424TimestampFormat  = "2006-01-02T15:04:05.000000Z07:00"
+	syslogRFC3164TimestampFormat  = time.Stamp
+)
+
+type SyslogFormatterConfig struct {
+	Format SyslogFormat
+
+	// facility of all entries (defaults to user)
+	Facility *int
+
+	// HOSTNAME (defaults to os.Hostname())
+	Hostname string
+
+	// APP-NAME when WhoAsMsgID is set, or when an entry has no logger name
+	AppName string
+
+	// place the logger name in MSGID and AppName in APP-NAME, rather than the logger name in APP-NAME
+	WhoAsMsgID bool
+
+	// SD-ID of the structured data element holding the structured fields (RFC 5424 only)
+	StructuredDataID string
+}
+
+// formats entries as syslog messages (without framing)
+type SyslogFormatter struct {
+	config   SyslogFormatterConfig
+	facility int
+	procID   string
+}
+
+func NewSyslogFormatter(config SyslogFormatterConfig) (*SyslogFormatter, error) {
+	facility := SyslogFacilityUser
+	if config.Facility != nil {
+		facility = *config.Facility
+	}
+
+	if facility < 0 || facility > 23 {
+		return nil, fmt.Errorf("invalid syslog facility %d", facility)
+	}
+
+	if config.Hostname == "" {
+		config.Hostname, _ = os.Hostname()
+	}
+
+	if config.StructuredDataID == "" {
+		config.StructuredDataID = syslogDefaultStructuredDataID
+	}
+
+	return &SyslogFormatter{
+		config:   config,
+		facility: facility,
+		procID:   strconv.Itoa(os.Getpid()),
+	}, nil
+}
+
+func (f *SyslogFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	switch f.config.Format {
+	case SyslogFormatRFC3164:
+		return f.formatRFC3164(entry), nil
+	default:
+		return f.formatRFC5424(entry), nil
+	}
+}
+
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD-ID name="value"...] MSG
+func (f *SyslogFormatter) formatRFC5424(entry *logrus.Entry) []byte {
+	buffer := bytes.Buffer{}
+	who := getSyslogWho(entry)
+
+	appName := who
+	msgID := syslogNilValue
+	if f.config.WhoAsMsgID || appName == "" {
+		appName = f.config.AppName
+		if f.config.WhoAsMsgID && who != "" {
+			msgID = who
+		}
+	}
+
+	fmt.Fprintf(&buffer, "<%d>1 %s %s %s %s %s ", // nolint: errcheck
+		f.getPriority(entry.Level),
+		entry.Time.Format(syslogRFC5424TimestampFormat),
+		getSyslogHeaderField(f.config.Hostname, 255),
+		getSyslogHeaderField(appName, 48),
+		getSyslogHeaderField(f.procID, 128),
+		getSyslogHeaderField(msgID, 32))
+
+	fieldKeys := getSyslogFieldKeys(entry.Data)
+	if len(fieldKeys) == 0 {
+		buffer.WriteString(syslogNilValue) // nolint: errcheck
+	} else {
+		buffer.WriteString("[" + f.config.StructuredDataID) // nolint: errcheck
+		for _, fieldKey := range fieldKeys {
+			fmt.Fprintf(&buffer, ` %s="%s"`, // nolint: errcheck
+				getSyslogParamName(fieldKey),
+				escapeSyslogParamValue(getFieldValueString(entry.Data[fieldKey])))
+		}
+		buffer.WriteString("]") // nolint: errcheck
+	}
+
+	if entry.Message != "" {
+		buffer.WriteString(" " + entry.Message) // nolint: errcheck
+	}
+
+	return buffer.Bytes()
+}
+
+// <PRI>Mmm dd hh:mm:ss HOSTNAME TAG[PID]: MSG key=value...
+func (f *SyslogFormatter) formatRFC3164(entry *logrus.Entry) []byte {
+	buffer := bytes.Buffer{}
+
+	tag := getSyslogWho(entry)
+	if f.config.WhoAsMsgID || tag == "" {
+		tag = f.config.AppName
+	}
+
+	fmt.Fprintf(&buffer, "<%d>%s %s %s[%s]: %s", // nolint: errcheck
+		f.getPriority(entry.Level),
+		entry.Time.Format(syslogRFC3164TimestampFormat),
+		getSyslogHeaderField(f.config.Hostname, 255),
+		getSyslogHeaderField(tag, 32),
+		f.procID,
+		entry.Message)
+
+	for _, fieldKey := range getSyslogFieldKeys(entry.Data) {
+		fmt.Fprintf(&buffer, " %s=%s", fieldKey, strconv.Quote(getFieldValueString(entry.Data[fieldKey]))) // nolint: errcheck
+	}
+
+	return buffer.Bytes()
+}
+
+func (f *SyslogFormatter) getPriority(level logrus.Level) int {
+	return f.facility*8 + getSyslogSeverity(level)
+}
+
+// maps logrus levels to syslog severities, RFC 5424 section 6.2.1
+func getSyslogSeverity(level logrus.Level) int {
+	switch level {
+	case logrus.PanicLevel:
+		return 0
+	case logrus.FatalLevel:
+		return 2
+	case logrus.ErrorLevel:
+		return 3
+	case logrus.WarnLevel:
+		return 4
+	case logrus.InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
+
+func getSyslogWho(entry *logrus.Entry) string {
+	who, ok := entry.Data["who"].(string)
+	if !ok {
+		return ""
+	}
+
+	return who
+}
+
+// returns the sorted keys of the fields which should be emitted as structured data
+func getSyslogFieldKeys(fields logrus.Fields) []string {
+	var fieldKeys []string
+
+	for fieldKey := range fields {
+		switch fieldKey {
+		case "who", "ctx":
+		default:
+			fieldKeys = append(fieldKeys, fieldKey)
+		}
+	}
+
+	sort.Strings(fieldKeys)
+	return fieldKeys
+}
+
+// header fields are printable US-ASCII without spaces, limited in length
+func getSyslogHeaderField(value string, maxLength int) string {
+	if value == "" {
+		return syslogNilValue
+	}
+
+	value = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, value)
+
+	if len(value) > maxLength {
+		value = value[:maxLength]
+	}
+
+	return value
+}
+
+// PARAM-NAME is up to 32 printable US-ASCII characters, except '=', ' ', ']' and '"'
+func getSyslogParamName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+
+	if len(name) > 32 {
+		name = name[:32]
+	}
+
+	return name
+}
+
+// '"', '\' and ']' must be escaped in PARAM-VALUE
+func escapeSyslogParamValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(value)
+}
+
+type SyslogWriterConfig struct {
+
+	// "udp", "tcp", "unix" or "unixgram"
+	Network string
+
+	// host:port, or a socket path for unix networks
+	Address string
+
+	// framing of messages over stream transports (ignored for datagram transports)
+	Framing SyslogFraming
+
+	// timeout for connecting (defaults to 5 seconds)
+	DialTimeout time.Duration
+
+	// timeout for writing a single message (0 means no timeout)
+	WriteTimeout time.Duration
+}
+
+// an io.Writer sending each write as a syslog message, reconnecting on failure
+type SyslogWriter struct {
+	config SyslogWriterConfig
+	lock   sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+func NewSyslogWriter(config SyslogWriterConfig) (*SyslogWriter, error) {
+	switch config.Network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %q", config.Network)
+	}
+
+	if config.DialTimeout == 0 {
+		config.DialTimeout = 5 * time.Second
+	}
+
+	// connecting lazily lets the logger be created before the syslog daemon is up
+	return &SyslogWriter{
+		config: config,
+	}, nil
+}
+
+func (w *SyslogWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.closed {
+		return 0, os.ErrClosed
+	}
+
+	message := w.frame(bytes.TrimRight(p, "\n"))
+
+	// if the connection broke since the last write, reconnect and retry once
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = w.writeMessage(message); err == nil {
+			return len(p), nil
+		}
+
+		w.closeConn()
+	}
+
+	return 0, fmt.Errorf("failed to write to syslog at %s, %w", w.config.Address, err)
+}
+
+// Close closes the connection to the syslog server
+func (w *SyslogWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.closed = true
+
+	return w.closeConn()
+}
+
+func (w *SyslogWriter) writeMessage(message []byte) error {
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.config.Network, w.config.Address, w.config.DialTimeout)
+		if err != nil {
+			return err
+		}
+
+		w.conn = conn
+	}
+
+	if w.config.WriteTimeout > 0 {
+		if err := w.conn.SetWriteDeadline(time.Now().Add(w.config.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+
+	_, err := w.conn.Write(message)
+	return err
+}
+
+func (w *SyslogWriter) closeConn() error {
+	if w.conn == nil {
+		return nil
+	}
+
+	err := w.conn.Close()
+	w.conn = nil
+
+	return err
+}
+
+func (w *SyslogWriter) frame(message []byte) []byte {
+	if !w.isStream() {
+		return message
+	}
+
+	switch w.config.Framing {
+	case SyslogFramingNonTransparent:
+
+		// newlines inside the message would terminate it early
+		return append(bytes.ReplaceAll(message, []byte("\n"), []byte(" ")), '\n')
+	default:
+		return append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+}
+
+func (w *SyslogWriter) isStream() bool {
+	switch w.config.Network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return true
+	default:
+		return false
+	}
+}
+
+// NewSyslogLoggerus creates a logger which sends entries to a syslog server
+func NewSyslogLoggerus(name string,
+	level logrus.Level,
+	formatterConfig SyslogFormatterConfig,
+	writerConfig SyslogWriterConfig) (*Loggerus, error) {
+
+	syslogFormatter, err := NewSyslogFormatter(formatterConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	syslogWriter, err := NewSyslogWriter(writerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewLoggerus(name, level, syslogWriter, syslogFormatter)
+}
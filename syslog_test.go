@@ -0,0 +1,237 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/suite"
+)
+
+type syslogSuite struct {
+	suite.Suite
+}
+
+func (suite *syslogSuite) TestFormatRFC5424() {
+	facility := SyslogFacilityLocal0
+	formatter, err := NewSyslogFormatter(SyslogFormatterConfig{
+		Facility: &facility,
+		Hostname: "host",
+	})
+	suite.Require().NoError(err)
+
+	entry := &logrus.Entry{
+		Time:    time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC),
+		Level:   logrus.WarnLevel,
+		Message: "Something happened",
+		Data: logrus.Fields{
+			"who":   "controller.worker",
+			"id":    7,
+			"quote": `a"b]c\d`,
+		},
+	}
+
+	formatted, err := formatter.Format(entry)
+	suite.Require().NoError(err)
+
+	// local0 (16) * 8 + warning (4)
+	suite.Require().Equal(`<132>1 2021-03-04T10:00:00.000000Z host controller.worker `+
+		strconv.Itoa(os.Getpid())+
+		` - [more@32473 id="7" quote="a\"b\]c\\d"] Something happened`,
+		string(formatted))
+}
+
+func (suite *syslogSuite) TestFormatRFC5424WhoAsMsgID() {
+	formatter, err := NewSyslogFormatter(SyslogFormatterConfig{
+		Hostname:   "host",
+		AppName:    "nuclio",
+		WhoAsMsgID: true,
+	})
+	suite.Require().NoError(err)
+
+	formatted, err := formatter.Format(&logrus.Entry{
+		Time:    time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC),
+		Level:   logrus.ErrorLevel,
+		Message: "Failed",
+		Data:    logrus.Fields{"who": "processor"},
+	})
+	suite.Require().NoError(err)
+
+	// user (1) * 8 + error (3)
+	suite.Require().Equal(`<11>1 2021-03-04T10:00:00.000000Z host nuclio `+
+		strconv.Itoa(os.Getpid())+
+		` processor - Failed`,
+		string(formatted))
+}
+
+func (suite *syslogSuite) TestFormatRFC3164() {
+	formatter, err := NewSyslogFormatter(SyslogFormatterConfig{
+		Format:   SyslogFormatRFC3164,
+		Hostname: "host",
+	})
+	suite.Require().NoError(err)
+
+	formatted, err := formatter.Format(&logrus.Entry{
+		Time:    time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC),
+		Level:   logrus.DebugLevel,
+		Message: "Hello",
+		Data:    logrus.Fields{"who": "app", "key": "value", "err": errors.New("refused")},
+	})
+	suite.Require().NoError(err)
+
+	suite.Require().Equal(`<15>Mar  4 10:00:00 host app[`+strconv.Itoa(os.Getpid())+`]: Hello err="refused" key="value"`,
+		string(formatted))
+}
+
+func (suite *syslogSuite) TestUDP() {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	defer listener.Close() // nolint: errcheck
+
+	loggerInstance, err := NewSyslogLoggerus("app",
+		logrus.InfoLevel,
+		SyslogFormatterConfig{},
+		SyslogWriterConfig{Network: "udp", Address: listener.LocalAddr().String()})
+	suite.Require().NoError(err)
+
+	loggerInstance.InfoWith("Over UDP", "key", "value")
+
+	suite.Require().NoError(listener.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	buffer := make([]byte, 4096)
+	n, _, err := listener.ReadFrom(buffer)
+	suite.Require().NoError(err)
+
+	message := string(buffer[:n])
+	suite.Require().True(strings.HasPrefix(message, "<14>1 "), message)
+	suite.Require().True(strings.HasSuffix(message, `[more@32473 key="value"] Over UDP`), message)
+}
+
+func (suite *syslogSuite) TestTCPOctetCountingAndReconnect() {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	suite.Require().NoError(err)
+	defer listener.Close() // nolint: errcheck
+
+	messagesChan := make(chan string, 100)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go suite.readOctetCountedMessages(conn, messagesChan)
+		}
+	}()
+
+	loggerInstance, err := NewSyslogLoggerus("app",
+		logrus.InfoLevel,
+		SyslogFormatterConfig{},
+		SyslogWriterConfig{Network: "tcp", Address: listener.Addr().String()})
+	suite.Require().NoError(err)
+
+	loggerInstance.InfoWith("First\nmultiline")
+	suite.Require().True(strings.HasSuffix(suite.receive(messagesChan), "First\nmultiline"))
+
+	// break the connection from the writer's side, as if the server went away
+	writer := loggerInstance.GetOutput().(*SyslogWriter)
+	writer.lock.Lock()
+	writer.conn.Close() // nolint: errcheck
+	writer.lock.Unlock()
+
+	loggerInstance.InfoWith("Second")
+	suite.Require().True(strings.HasSuffix(suite.receive(messagesChan), "Second"))
+}
+
+func (suite *syslogSuite) TestUnixgram() {
+	if runtime.GOOS == "windows" {
+		suite.T().Skip("unixgram is not supported on windows")
+	}
+
+	tempDir, err := ioutil.TempDir("", "loggerus-syslog-")
+	suite.Require().NoError(err)
+	defer os.RemoveAll(tempDir) // nolint: errcheck
+
+	socketPath := filepath.Join(tempDir, "log.sock")
+	listener, err := net.ListenPacket("unixgram", socketPath)
+	suite.Require().NoError(err)
+	defer listener.Close() // nolint: errcheck
+
+	loggerInstance, err := NewSyslogLoggerus("app",
+		logrus.InfoLevel,
+		SyslogFormatterConfig{Format: SyslogFormatRFC3164},
+		SyslogWriterConfig{Network: "unixgram", Address: socketPath})
+	suite.Require().NoError(err)
+
+	loggerInstance.ErrorWith("Over unix")
+
+	suite.Require().NoError(listener.SetReadDeadline(time.Now().Add(5 * time.Second)))
+	buffer := make([]byte, 4096)
+	n, _, err := listener.ReadFrom(buffer)
+	suite.Require().NoError(err)
+	suite.Require().True(strings.HasSuffix(string(buffer[:n]), "]: Over unix"))
+}
+
+func (suite *syslogSuite) readOctetCountedMessages(conn net.Conn, messagesChan chan string) {
+	defer conn.Close() // nolint: errcheck
+	reader := bufio.NewReader(conn)
+
+	for {
+		lengthString, err := reader.ReadString(' ')
+		if err != nil {
+			return
+		}
+
+		length, err := strconv.Atoi(strings.TrimSpace(lengthString))
+		if err != nil {
+			return
+		}
+
+		message := make([]byte, length)
+		if _, err := io.ReadFull(reader, message); err != nil {
+			return
+		}
+
+		messagesChan <- string(message)
+	}
+}
+
+func (suite *syslogSuite) receive(messagesChan chan string) string {
+	select {
+	case message := <-messagesChan:
+		return message
+	case <-time.After(5 * time.Second):
+		suite.Require().Fail("Timed out waiting for syslog message")
+	}
+
+	return ""
+}
+
+func TestSyslogTestSuite(t *testing.T) {
+	suite.Run(t, new(syslogSuite))
+}
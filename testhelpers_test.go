@@ -0,0 +1,98 @@
+/*
+Copyright 2021 The Nuclio Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package loggerus
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// a goroutine safe buffer of JSONFormatter formatted entries
+type testOutput struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (o *testOutput) Write(p []byte) (int, error) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.buffer.Write(p)
+}
+
+func (o *testOutput) getContents() string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.buffer.String()
+}
+
+// returns "<who>:<what>" of every entry written
+func (o *testOutput) getEntries() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	var entries []string
+	for _, line := range strings.Split(strings.TrimSpace(o.buffer.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		entry := map[string]interface{}{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			panic(err)
+		}
+
+		who, _ := entry["who"].(string)
+		entries = append(entries, who+":"+entry["what"].(string))
+	}
+
+	return entries
+}
+
+// an output recording flushes and closes, optionally failing to close
+type testClosingOutput struct {
+	testOutput
+	flushes  int
+	closed   bool
+	closeErr error
+}
+
+func (o *testClosingOutput) Flush() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.flushes++
+	return nil
+}
+
+func (o *testClosingOutput) getFlushes() int {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	return o.flushes
+}
+
+func (o *testClosingOutput) Close() error {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	o.closed = true
+	return o.closeErr
+}
@@ -126,36 +126,10 @@ func (f *TextFormatter) getFieldsOutput(fields logrus.Fields) string {
 			continue
 		}
 
-		// if we're dealing with a struct, use json
-		switch reflect.Indirect(reflect.ValueOf(fieldValue)).Kind() {
-		case reflect.Slice, reflect.Map, reflect.Struct:
-			fieldValueBytes, _ := json.Marshal(fieldValue)
-
-			// if it's short - add to single line. otherwise to block
-			if len(fieldValueBytes) <= maxVariableLen {
-				singleLineKV[fieldKey] = string(fieldValueBytes)
-			} else {
-				blockBuffer := bytes.NewBuffer([]byte{})
-
-				if err := json.Indent(blockBuffer, fieldValueBytes, "", "\t"); err != nil {
-					blockBuffer.WriteString(fmt.Sprintf("Failed to encode: %s", err.Error())) // nolint: errcheck
-				}
-
-				blockKV[fieldKey] = blockBuffer.String()
-			}
-
-		case reflect.String:
-			stringFieldValue := fmt.Sprintf("%s", fieldValue)
-
-			// if there are newlines in output, add to block
-			if strings.Contains(stringFieldValue, "\n") {
-				blockKV[fieldKey] = stringFieldValue
-			} else {
-				singleLineKV[fieldKey] = fmt.Sprintf(`"%s"`, fieldValue)
-			}
-
-		default:
-			singleLineKV[fieldKey] = fmt.Sprintf("%v", fieldValue)
+		if fieldOutput, isBlock := getFieldOutput(fieldValue, maxVariableLen); isBlock {
+			blockKV[fieldKey] = fieldOutput
+		} else {
+			singleLineKV[fieldKey] = fieldOutput
 		}
 	}
 
@@ -184,6 +158,42 @@ func (f *TextFormatter) getFieldsOutput(fields logrus.Fields) string {
 	return fieldsOutput
 }
 
+// returns the output of a field value, and whether it should be written as a block rather than inline
+func getFieldOutput(fieldValue interface{}, maxVariableLen int) (string, bool) {
+
+	// if we're dealing with a struct, use json
+	switch reflect.Indirect(reflect.ValueOf(fieldValue)).Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+		fieldValueBytes, _ := json.Marshal(fieldValue)
+
+		// if it's short - add to single line. otherwise to block
+		if len(fieldValueBytes) <= maxVariableLen {
+			return string(fieldValueBytes), false
+		}
+
+		blockBuffer := bytes.NewBuffer([]byte{})
+
+		if err := json.Indent(blockBuffer, fieldValueBytes, "", "\t"); err != nil {
+			blockBuffer.WriteString(fmt.Sprintf("Failed to encode: %s", err.Error())) // nolint: errcheck
+		}
+
+		return blockBuffer.String(), true
+
+	case reflect.String:
+		stringFieldValue := fmt.Sprintf("%s", fieldValue)
+
+		// if there are newlines in output, add to block
+		if strings.Contains(stringFieldValue, "\n") {
+			return stringFieldValue, true
+		}
+
+		return fmt.Sprintf(`"%s"`, fieldValue), false
+
+	default:
+		return fmt.Sprintf("%v", fieldValue), false
+	}
+}
+
 func (f *TextFormatter) getFormattedWho(data logrus.Fields) string {
 	who, ok := data["who"]
 	if ok {